@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// cniArg returns the value of key in CNI_ARGS, which is a list of KEY=VALUE
+// pairs separated by semicolons, such as the K8S_POD_NAME which is set by
+// Kubernetes. The second return value is false if key is not set.
+func cniArg(key string) (string, bool, error) {
+	args := os.Getenv("CNI_ARGS")
+	if args == "" {
+		return "", false, nil
+	}
+	for _, pair := range strings.Split(args, ";") {
+		k, v, ok := strings.Cut(pair, "=")
+		if !ok {
+			return "", false, fmt.Errorf("invalid CNI_ARGS pair: %q", pair)
+		}
+		if k == key {
+			return v, true, nil
+		}
+	}
+	return "", false, nil
+}
+
+// argOr returns the value of key in CNI_ARGS, or def if it is not set or is
+// empty.
+func argOr(key, def string) (string, error) {
+	v, ok, err := cniArg(key)
+	if err != nil {
+		return "", fmt.Errorf("argOr: %w", err)
+	}
+	if !ok || v == "" {
+		return def, nil
+	}
+	return v, nil
+}
+
+// argInt returns the value of key in CNI_ARGS as an int. If a default is
+// given, it is returned when the value is not set or cannot be parsed;
+// otherwise, those are errors.
+func argInt(key string, def ...int) (int, error) {
+	v, ok, err := cniArg(key)
+	if err != nil {
+		return 0, fmt.Errorf("argInt: %w", err)
+	}
+	if !ok {
+		if len(def) > 0 {
+			return def[0], nil
+		}
+		return 0, fmt.Errorf("argInt: %s is not set in CNI_ARGS", key)
+	}
+	i, err := strconv.Atoi(v)
+	if err != nil {
+		if len(def) > 0 {
+			return def[0], nil
+		}
+		return 0, fmt.Errorf("argInt: invalid %s: %w", key, err)
+	}
+	return i, nil
+}
+
+// argBool is like [argInt], but returns the value as a bool, which may be any
+// of the values accepted by [strconv.ParseBool], such as true, false, 1 or 0.
+func argBool(key string, def ...bool) (bool, error) {
+	v, ok, err := cniArg(key)
+	if err != nil {
+		return false, fmt.Errorf("argBool: %w", err)
+	}
+	if !ok {
+		if len(def) > 0 {
+			return def[0], nil
+		}
+		return false, fmt.Errorf("argBool: %s is not set in CNI_ARGS", key)
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		if len(def) > 0 {
+			return def[0], nil
+		}
+		return false, fmt.Errorf("argBool: invalid %s: %w", key, err)
+	}
+	return b, nil
+}
@@ -0,0 +1,40 @@
+package main
+
+import "testing"
+
+func TestArgFuncs(t *testing.T) {
+	t.Setenv("CNI_ARGS", "IgnoreUnknown=1;K8S_POD_NAMESPACE=default;VLAN=42;TRUNK=true")
+
+	stdin := []byte(`{"type": "gator", "plugin": "debug", "patch": "{\"vlan\": {{ argInt \"VLAN\" }}, \"vlanTrunk\": {{ argBool \"TRUNK\" }}, \"hairpinMode\": {{ argBool \"HAIRPIN\" false }}, \"namespace\": {{ argOr \"K8S_POD_NAMESPACE\" \"kube-system\" | toJson }}}"}`)
+	conf, err := parseConf(stdin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"hairpinMode":false,"namespace":"default","type":"debug","vlan":42,"vlanTrunk":true}`; string(conf.downstreamConfig) != want {
+		t.Fatalf("expected %s, got %s", want, conf.downstreamConfig)
+	}
+}
+
+func TestArgFuncsInvalid(t *testing.T) {
+	t.Setenv("CNI_ARGS", "VLAN=forty-two;TRUNK=maybe")
+	if _, err := argInt("VLAN"); err == nil {
+		t.Error("expected error for an invalid integer")
+	}
+	if got, err := argInt("VLAN", 1); err != nil || got != 1 {
+		t.Errorf("expected the default for an invalid integer, got %d, %v", got, err)
+	}
+	if _, err := argBool("TRUNK"); err == nil {
+		t.Error("expected error for an invalid boolean")
+	}
+	if _, err := argInt("MISSING"); err == nil {
+		t.Error("expected error for a missing key without a default")
+	}
+	if got, err := argOr("MISSING", "fallback"); err != nil || got != "fallback" {
+		t.Errorf("expected the default for a missing key, got %q, %v", got, err)
+	}
+
+	t.Setenv("CNI_ARGS", "VLAN")
+	if _, err := argOr("VLAN", ""); err == nil {
+		t.Error("expected error for an invalid CNI_ARGS pair")
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"unicode/utf16"
+
+	"github.com/containernetworking/cni/pkg/types"
+)
+
+// canonicalize returns config serialized with the RFC 8785 JSON Canonicalization
+// Scheme (JCS): object keys are sorted by their UTF-16 code units, there is no
+// whitespace, numbers are formatted as ECMAScript doubles and strings are only
+// escaped where JSON requires it. The same value always has the same bytes, so
+// the output can be signed or hashed.
+func canonicalize(config []byte) ([]byte, *types.Error) {
+	var v interface{}
+	dec := json.NewDecoder(bytes.NewReader(config))
+	dec.UseNumber()
+	if err := dec.Decode(&v); err != nil {
+		return nil, types.NewError(ErrMergeJSONFailed, "failed to canonicalize downstream config", err.Error())
+	}
+	out := &bytes.Buffer{}
+	if err := writeCanonical(out, v); err != nil {
+		return nil, types.NewError(ErrMergeJSONFailed, "failed to canonicalize downstream config", err.Error())
+	}
+	return out.Bytes(), nil
+}
+
+// writeCanonical writes v, which was decoded with UseNumber, to out as JCS.
+func writeCanonical(out *bytes.Buffer, v interface{}) error {
+	switch t := v.(type) {
+	case nil:
+		out.WriteString("null")
+	case bool:
+		out.WriteString(strconv.FormatBool(t))
+	case json.Number:
+		f, err := strconv.ParseFloat(string(t), 64)
+		if err != nil {
+			return err
+		}
+		s, err := canonicalNumber(f)
+		if err != nil {
+			return err
+		}
+		out.WriteString(s)
+	case string:
+		writeCanonicalString(out, t)
+	case []interface{}:
+		out.WriteByte('[')
+		for i, e := range t {
+			if i > 0 {
+				out.WriteByte(',')
+			}
+			if err := writeCanonical(out, e); err != nil {
+				return err
+			}
+		}
+		out.WriteByte(']')
+	case map[string]interface{}:
+		keys := make([]string, 0, len(t))
+		for k := range t {
+			keys = append(keys, k)
+		}
+		sort.Slice(keys, func(i, j int) bool {
+			return lessUTF16(keys[i], keys[j])
+		})
+		out.WriteByte('{')
+		for i, k := range keys {
+			if i > 0 {
+				out.WriteByte(',')
+			}
+			writeCanonicalString(out, k)
+			out.WriteByte(':')
+			if err := writeCanonical(out, t[k]); err != nil {
+				return err
+			}
+		}
+		out.WriteByte('}')
+	default:
+		return fmt.Errorf("unexpected JSON value: %v", v)
+	}
+	return nil
+}
+
+// canonicalNumber formats f like ECMAScript's Number.prototype.toString, which
+// uses exponents only for very large and very small magnitudes.
+func canonicalNumber(f float64) (string, error) {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return "", fmt.Errorf("number is not finite: %v", f)
+	}
+	if f == 0 {
+		return "0", nil
+	}
+	format := byte('f')
+	if abs := math.Abs(f); abs < 1e-6 || abs >= 1e21 {
+		format = 'e'
+	}
+	s := strconv.FormatFloat(f, format, -1, 64)
+	if format == 'e' {
+		// Go pads exponents to two digits, such as 1e-07, but ECMAScript does
+		// not
+		if n := len(s); n >= 4 && s[n-4] == 'e' && s[n-2] == '0' {
+			s = s[:n-2] + s[n-1:]
+		}
+	}
+	return s, nil
+}
+
+// writeCanonicalString writes s to out as a JSON string, escaping only quotes,
+// backslashes and control characters.
+func writeCanonicalString(out *bytes.Buffer, s string) {
+	out.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '"':
+			out.WriteString(`\"`)
+		case '\\':
+			out.WriteString(`\\`)
+		case '\b':
+			out.WriteString(`\b`)
+		case '\f':
+			out.WriteString(`\f`)
+		case '\n':
+			out.WriteString(`\n`)
+		case '\r':
+			out.WriteString(`\r`)
+		case '\t':
+			out.WriteString(`\t`)
+		default:
+			if r < 0x20 {
+				fmt.Fprintf(out, `\u%04x`, r)
+			} else {
+				out.WriteRune(r)
+			}
+		}
+	}
+	out.WriteByte('"')
+}
+
+// lessUTF16 returns true if a sorts before b when both are compared as UTF-16
+// code units, which is how JCS sorts keys.
+func lessUTF16(a, b string) bool {
+	ua := utf16.Encode([]rune(a))
+	ub := utf16.Encode([]rune(b))
+	for i := 0; i < len(ua) && i < len(ub); i++ {
+		if ua[i] != ub[i] {
+			return ua[i] < ub[i]
+		}
+	}
+	return len(ua) < len(ub)
+}
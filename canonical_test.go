@@ -0,0 +1,56 @@
+package main
+
+import "testing"
+
+func TestCanonicalize(t *testing.T) {
+	// The example from RFC 8785, section 3.2.2
+	input := `{
+		"numbers": [333333333.33333329, 1E30, 4.50, 2e-3, 0.000000000000000000000000001],
+		"string": "\u20ac$\u000F\u000aA'\u0042\u0022\u005c\\\"\/",
+		"literals": [null, true, false]
+	}`
+	want := `{"literals":[null,true,false],"numbers":[333333333.3333333,1e+30,4.5,0.002,1e-27],"string":"€$\u000f\nA'B\"\\\\\"/"}`
+
+	got, err := canonicalize([]byte(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != want {
+		t.Fatalf("expected %s, got %s", want, got)
+	}
+	again, err := canonicalize(got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(again) != want {
+		t.Fatalf("expected canonical output to be stable, got %s", again)
+	}
+}
+
+func TestCanonicalizeKeyOrder(t *testing.T) {
+	// Keys are sorted by UTF-16 code units, so the astral "😂" (U+D83D U+DE02)
+	// sorts before "ﬁ" (U+FB01), unlike when sorted by code points
+	got, err := canonicalize([]byte(`{"ﬁ": 1, "😂": 2, "b": 3, "a": {"z": 1, "y": 2}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"a":{"y":2,"z":1},"b":3,"😂":2,"ﬁ":1}`; string(got) != want {
+		t.Fatalf("expected %s, got %s", want, got)
+	}
+}
+
+func TestParseConfCanonicalize(t *testing.T) {
+	stdin := []byte(`{"type": "gator", "plugin": "debug", "canonicalize": true, "patch": "{\"mtu\": 1.4E3, \"b\": 1, \"a\": 2}"}`)
+	conf, err := parseConf(stdin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(conf.downstreamConfig), `{"a":2,"b":1,"mtu":1400,"type":"debug"}`; got != want {
+		t.Fatalf("expected %s, got %s", want, got)
+	}
+
+	stdin = []byte(`{"type": "gator", "plugin": "debug", "canonicalize": true, "prettyDownstream": true}`)
+	if _, err := parseConf(stdin); err == nil {
+		t.Fatal("expected canonicalize and prettyDownstream to be exclusive")
+	}
+}
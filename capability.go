@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/containernetworking/cni/pkg/types"
+)
+
+// probeTimeout is how long each candidate plugin has to respond to VERSION
+// when resolving [PluginConfig.PluginByCapability].
+const probeTimeout = 5 * time.Second
+
+// experimentalEnabled returns true if feature is in GATOR_EXPERIMENTAL, which
+// is a comma-separated list of experimental features.
+func experimentalEnabled(feature string) bool {
+	return slices.Contains(strings.Split(os.Getenv("GATOR_EXPERIMENTAL"), ","), feature)
+}
+
+// resolvePluginByCapability sets [PluginConfig.Plugin] to the name of the
+// first plugin in CNI_PATH which reports [PluginConfig.PluginByCapability]. It
+// does nothing if PluginByCapability is not set. If GATOR_PATH_CACHE is set,
+// the discovered path is cached there until it no longer exists.
+func (conf *PluginConfig) resolvePluginByCapability(ctx context.Context) *types.Error {
+	capability := conf.PluginByCapability
+	if capability == "" {
+		return nil
+	}
+	if !experimentalEnabled("pluginByCapability") {
+		return types.NewError(
+			types.ErrInvalidNetworkConfig,
+			"pluginByCapability is experimental",
+			"set GATOR_EXPERIMENTAL=pluginByCapability to enable it",
+		)
+	}
+
+	cacheFile := os.Getenv("GATOR_PATH_CACHE")
+	key := "capability:" + capability + "@" + os.Getenv("CNI_PATH")
+	if cacheFile != "" {
+		if p, ok := readPathCache(cacheFile)[key]; ok && isExecutable(p) && conf.isAllowed(filepath.Base(p)) {
+			conf.Plugin = filepath.Base(p)
+			return nil
+		}
+	}
+
+	p, err := conf.findPluginByCapability(ctx, capability)
+	if err != nil {
+		return err
+	}
+	if cacheFile != "" {
+		writePathCache(cacheFile, key, p)
+	}
+	conf.Plugin = filepath.Base(p)
+	return nil
+}
+
+// findPluginByCapability calls each executable in CNI_PATH with the VERSION
+// command, and returns the path of the first whose output has the capability
+// in its capabilities list. This is not part of the CNI spec, so only plugins
+// which add it are found. Plugins which are shadowed by one of the same name
+// earlier in CNI_PATH are not called, since they would never be delegated to,
+// and neither are plugins which are not in [PluginConfig.AllowedPlugins].
+func (conf *PluginConfig) findPluginByCapability(ctx context.Context, capability string) (string, *types.Error) {
+	attr, attrErr := conf.sysProcAttr()
+	if attrErr != nil {
+		return "", types.NewError(types.ErrInvalidNetworkConfig, "invalid runAsUser or runAsGroup", attrErr.Error())
+	}
+	self, _ := os.Executable()
+	stdin, _ := json.Marshal(map[string]interface{}{"cniVersion": conf.data["cniVersion"]})
+	env := setEnv(os.Environ(), "CNI_COMMAND", "VERSION")
+
+	seen := map[string]bool{}
+	for _, dir := range cniPaths() {
+		entries, _ := os.ReadDir(dir)
+		for _, entry := range entries {
+			name := entry.Name()
+			p := filepath.Join(dir, name)
+			if seen[name] || !conf.isAllowed(name) || !isExecutable(p) {
+				continue
+			}
+			seen[name] = true
+			if self != "" && sameFile(p, self) {
+				continue
+			}
+
+			probeCtx, cancel := context.WithTimeout(ctx, probeTimeout)
+			stdout, _, exitcode := delegate(probeCtx, p, stdin, env, attr)
+			cancel()
+			if exitcode != 0 {
+				continue
+			}
+			version := struct {
+				Capabilities []string `json:"capabilities"`
+			}{}
+			if json.Unmarshal(stdout, &version) == nil && slices.Contains(version.Capabilities, capability) {
+				return p, nil
+			}
+		}
+	}
+	return "", types.NewError(
+		ErrPluginNotFound,
+		fmt.Sprintf("no plugin in CNI_PATH has the capability: %s", capability),
+		fmt.Sprintf("checked: %v", cniPaths()),
+	)
+}
+
+// isAllowed returns true if [PluginConfig.AllowedPlugins] is empty or includes
+// plugin.
+func (conf *PluginConfig) isAllowed(plugin string) bool {
+	return len(conf.AllowedPlugins) == 0 || slices.Contains(conf.AllowedPlugins, plugin)
+}
+
+// sameFile returns true if a and b are the same file.
+func sameFile(a, b string) bool {
+	sa, err := os.Stat(a)
+	if err != nil {
+		return false
+	}
+	sb, err := os.Stat(b)
+	return err == nil && os.SameFile(sa, sb)
+}
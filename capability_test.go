@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseConfPluginByCapability(t *testing.T) {
+	first, second := t.TempDir(), t.TempDir()
+	probed := filepath.Join(t.TempDir(), "probed")
+	t.Setenv("CNI_PATH", first+":"+second)
+	t.Setenv("CNI_COMMAND", "ADD")
+	t.Setenv("GATOR_PATH_CACHE", filepath.Join(t.TempDir(), "paths.json"))
+
+	writeFakePlugin(t, first, "bridge", `echo '{"cniVersion": "1.0.0", "supportedVersions": ["1.0.0"]}'`)
+	writeFakePlugin(t, first, "broken", `exit 1`)
+	writeFakePlugin(t, second, "portmap", `echo probed >> `+probed+`; echo '{"cniVersion": "1.0.0", "supportedVersions": ["1.0.0"], "capabilities": ["portMappings"]}'`)
+	// Shadowed by the bridge earlier in CNI_PATH, so it is never called
+	writeFakePlugin(t, second, "bridge", `echo '{"cniVersion": "1.0.0", "capabilities": ["portMappings"]}'`)
+
+	stdin := []byte(`{"cniVersion": "1.0.0", "type": "gator", "pluginByCapability": "portMappings"}`)
+	if _, err := parseConf(stdin); err == nil || err.Details != "set GATOR_EXPERIMENTAL=pluginByCapability to enable it" {
+		t.Fatalf("expected pluginByCapability to require GATOR_EXPERIMENTAL, got %v", err)
+	}
+
+	t.Setenv("GATOR_EXPERIMENTAL", "pluginByCapability")
+	for i := 0; i < 2; i++ {
+		conf, err := parseConf(stdin)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if conf.Plugin != "portmap" {
+			t.Fatalf("expected portmap, got %q", conf.Plugin)
+		}
+		if want := `{"cniVersion":"1.0.0","type":"portmap"}`; string(conf.downstreamConfig) != want {
+			t.Fatalf("expected %s, got %s", want, conf.downstreamConfig)
+		}
+	}
+	if b, _ := os.ReadFile(probed); string(b) != "probed\n" {
+		t.Fatalf("expected portmap to be probed once and then cached, got %q", b)
+	}
+
+	stdin = []byte(`{"cniVersion": "1.0.0", "type": "gator", "pluginByCapability": "bandwidth"}`)
+	if _, err := parseConf(stdin); err == nil || err.Code != ErrPluginNotFound {
+		t.Fatalf("expected a plugin not found error, got %v", err)
+	}
+
+	stdin = []byte(`{"cniVersion": "1.0.0", "type": "gator", "plugin": "bridge", "pluginByCapability": "portMappings"}`)
+	if _, err := parseConf(stdin); err == nil || err.Msg != "plugin and pluginByCapability cannot be set together" {
+		t.Fatalf("expected plugin and pluginByCapability to be exclusive, got %v", err)
+	}
+}
+
+func TestPluginByCapabilityAllowedPlugins(t *testing.T) {
+	dir := t.TempDir()
+	called := filepath.Join(t.TempDir(), "called")
+	t.Setenv("CNI_PATH", dir)
+	t.Setenv("CNI_COMMAND", "ADD")
+	t.Setenv("GATOR_EXPERIMENTAL", "pluginByCapability")
+
+	writeFakePlugin(t, dir, "evil", `touch `+called+`; echo '{"cniVersion": "1.0.0", "capabilities": ["portMappings"]}'`)
+	writeFakePlugin(t, dir, "portmap", `echo '{"cniVersion": "1.0.0", "supportedVersions": ["1.0.0"], "capabilities": ["portMappings"]}'`)
+
+	stdin := []byte(`{"cniVersion": "1.0.0", "type": "gator", "pluginByCapability": "portMappings", "allowedPlugins": ["portmap"]}`)
+	conf, err := parseConf(stdin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf.Plugin != "portmap" {
+		t.Fatalf("expected portmap, got %q", conf.Plugin)
+	}
+	if _, err := os.Stat(called); err == nil {
+		t.Fatal("expected a plugin which is not allowed not to be executed")
+	}
+
+	stdin = []byte(`{"cniVersion": "1.0.0", "type": "gator", "pluginByCapability": "portMappings", "allowedPlugins": ["bridge"]}`)
+	if _, err := parseConf(stdin); err == nil || err.Code != ErrPluginNotFound {
+		t.Fatalf("expected a plugin not found error, got %v", err)
+	}
+	if _, err := os.Stat(called); err == nil {
+		t.Fatal("expected a plugin which is not allowed not to be executed")
+	}
+}
+
+func TestRunSkipBeforePluginResolution(t *testing.T) {
+	dir := t.TempDir()
+	called := filepath.Join(t.TempDir(), "called")
+	t.Setenv("CNI_PATH", dir)
+	t.Setenv("CNI_COMMAND", "DEL")
+	t.Setenv("GATOR_EXPERIMENTAL", "pluginByCapability")
+	writeFakePlugin(t, dir, "portmap", `touch `+called+`; echo '{"cniVersion": "1.0.0", "capabilities": ["portMappings"]}'`)
+
+	tests := map[string]string{
+		"disallowed plugin":  `{"type": "gator", "plugin": "portmap", "allowedPlugins": ["bridge"], "skip": ["DEL"]}`,
+		"pluginByCapability": `{"type": "gator", "pluginByCapability": "portMappings", "skip": ["DEL"]}`,
+	}
+	for name, stdin := range tests {
+		t.Run(name, func(t *testing.T) {
+			stdout := &bytes.Buffer{}
+			if code := run(nil, bytes.NewBufferString(stdin), stdout, &bytes.Buffer{}); code != 0 {
+				t.Fatalf("expected a skipped command to succeed, got %d: %s", code, stdout)
+			}
+			if stdout.String() != stdin {
+				t.Fatalf("expected stdin to be printed, got %s", stdout)
+			}
+			if _, err := os.Stat(called); err == nil {
+				t.Fatal("expected no plugin to be executed for a skipped command")
+			}
+		})
+	}
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// clock returns the current time. It can be replaced in tests.
+var clock = time.Now
+
+// now returns the time which templates see, from the now function. If
+// GATOR_FAKE_TIME is set to an RFC3339 time, that time is used instead of the
+// clock, so that time-based templates can be tested and audited.
+func now() (time.Time, error) {
+	fake := os.Getenv("GATOR_FAKE_TIME")
+	if fake == "" {
+		return clock(), nil
+	}
+	t, err := time.Parse(time.RFC3339, fake)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("now: invalid GATOR_FAKE_TIME: %w", err)
+	}
+	return t, nil
+}
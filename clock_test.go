@@ -0,0 +1,20 @@
+package main
+
+import "testing"
+
+func TestFakeTime(t *testing.T) {
+	t.Setenv("GATOR_FAKE_TIME", "2023-10-03T12:34:56Z")
+	stdin := []byte(`{"type": "gator", "plugin": "debug", "patch": "{\"date\": \"{{ dateInZone \"2006-01-02T15:04:05\" now \"UTC\" }}\"}"}`)
+	conf, err := parseConf(stdin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"date":"2023-10-03T12:34:56","type":"debug"}`; string(conf.downstreamConfig) != want {
+		t.Fatalf("expected %s, got %s", want, conf.downstreamConfig)
+	}
+
+	t.Setenv("GATOR_FAKE_TIME", "yesterday")
+	if _, err := parseConf(stdin); err == nil || err.Code != ErrInvalidPatchTemplate {
+		t.Fatalf("expected code %d, got %v", ErrInvalidPatchTemplate, err)
+	}
+}
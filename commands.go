@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/containernetworking/cni/pkg/types"
+)
+
+// runCommand runs the gator subcommand called name with args, and returns the
+// exit code.
+func runCommand(name string, args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	switch name {
+	case "check-plugin":
+		return checkPlugin(args, stdout, stderr)
+	case "list-funcs":
+		return listFuncs(stdout)
+	case "schema":
+		return printSchema(stdout)
+	case "validate":
+		return validate(stdin, stdout)
+	default:
+		fmt.Fprintf(stderr, "unknown command: %s\n", name)
+		return 2
+	}
+}
+
+// checkPlugin prints the path which the plugin named in args resolves to in
+// CNI_PATH.
+func checkPlugin(args []string, stdout, stderr io.Writer) int {
+	if len(args) != 1 {
+		fmt.Fprintln(stderr, "usage: gator check-plugin <name>")
+		return 2
+	}
+
+	pluginPath, err := getPluginPath(args[0])
+	if err != nil {
+		return handleError(stdout, err)
+	}
+	fmt.Fprintln(stdout, pluginPath)
+	return 0
+}
+
+// listFuncs prints the name of every template function, along with a
+// description of gator's own functions.
+func listFuncs(stdout io.Writer) int {
+	names := []string{}
+	for name := range funcMap(nil, funcOptions{}) {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	w := tabwriter.NewWriter(stdout, 0, 0, 2, ' ', 0)
+	for _, name := range names {
+		fmt.Fprintf(w, "%s\t%s\n", name, funcDescriptions[name])
+	}
+	w.Flush()
+	return 0
+}
+
+// validate checks the combination of fields in the config on stdin with
+// [PluginConfig.Validate], without executing any templates. Nothing is printed
+// if the config is valid.
+func validate(stdin io.Reader, stdout io.Writer) int {
+	input, err := readStdin(stdin)
+	if err != nil {
+		return handleError(stdout, err)
+	}
+	conf, jsonErr := decodeConf(input)
+	if jsonErr != nil {
+		err := types.NewError(types.ErrDecodingFailure, "failed to parse JSON config", jsonErr.Error())
+		return handleError(stdout, err)
+	}
+	if err := conf.Validate(); err != nil {
+		return handleError(stdout, err)
+	}
+	return 0
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/containernetworking/cni/pkg/types"
+)
+
+func TestRunCheckPlugin(t *testing.T) {
+	dir := t.TempDir()
+	plugin := writeFakePlugin(t, dir, "ok", `exit 0`)
+	t.Setenv("CNI_PATH", filepath.Join(dir, "missing")+":"+dir)
+
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	if code := run([]string{"check-plugin", "ok"}, nil, stdout, stderr); code != 0 {
+		t.Fatalf("expected exit code 0, got %d: %s", code, stderr)
+	}
+	if got := strings.TrimSpace(stdout.String()); got != plugin {
+		t.Fatalf("expected %s, got %s", plugin, got)
+	}
+
+	stdout, stderr = &bytes.Buffer{}, &bytes.Buffer{}
+	if code := run([]string{"check-plugin", "missing"}, nil, stdout, stderr); code != ErrPluginNotFound {
+		t.Fatalf("expected exit code %d, got %d", ErrPluginNotFound, code)
+	}
+	if !strings.Contains(stdout.String(), "not found") {
+		t.Fatalf("expected a not found error, got %q", stdout)
+	}
+
+	if code := run([]string{"check-plugin"}, nil, &bytes.Buffer{}, &bytes.Buffer{}); code != 2 {
+		t.Fatalf("expected usage exit code 2, got %d", code)
+	}
+}
+
+func TestRunListFuncs(t *testing.T) {
+	stdout := &bytes.Buffer{}
+	if code := run([]string{"list-funcs"}, nil, stdout, &bytes.Buffer{}); code != 0 {
+		t.Fatalf("expected exit code 0, got %d", code)
+	}
+
+	lines := map[string]string{}
+	for _, line := range strings.Split(strings.TrimSpace(stdout.String()), "\n") {
+		name, desc, _ := strings.Cut(line, " ")
+		lines[name] = strings.TrimSpace(desc)
+	}
+	if lines["cidrSubnet"] != funcDescriptions["cidrSubnet"] {
+		t.Fatalf("expected cidrSubnet with its description, got %q", lines["cidrSubnet"])
+	}
+	if _, ok := lines["toJson"]; !ok {
+		t.Fatal("expected sprig functions to be listed")
+	}
+	for name := range gatorFuncs(nil, funcOptions{}) {
+		if funcDescriptions[name] == "" {
+			t.Errorf("missing description for %s", name)
+		}
+	}
+}
+
+func TestRunSchema(t *testing.T) {
+	stdout := &bytes.Buffer{}
+	if code := run([]string{"schema"}, nil, stdout, &bytes.Buffer{}); code != 0 {
+		t.Fatalf("expected exit code 0, got %d", code)
+	}
+
+	schema := struct {
+		Properties map[string]interface{} `json:"properties"`
+	}{}
+	if err := json.Unmarshal(stdout.Bytes(), &schema); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"patch", "plugin", "skip"} {
+		if _, ok := schema.Properties[key]; !ok {
+			t.Errorf("expected the schema to include %s", key)
+		}
+	}
+	for _, key := range metaKeys {
+		if _, ok := schema.Properties[key]; !ok {
+			t.Errorf("missing schema property for %s", key)
+		}
+	}
+
+	path := filepath.Join(t.TempDir(), "schema.json")
+	if err := os.WriteFile(path, stdout.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	valid, err := os.ReadFile("testdata/route-override.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := validateSchema(path, valid); err != nil {
+		t.Fatalf("expected route-override.json to be valid: %v", err)
+	}
+	invalid := []byte(`{"type": "gator", "plugin": "debug", "patch": "{}", "patchURL": "https://example.com"}`)
+	if err := validateSchema(path, invalid); err == nil {
+		t.Fatal("expected patch and patchURL to be mutually exclusive")
+	}
+}
+
+func TestRunValidate(t *testing.T) {
+	stdout := &bytes.Buffer{}
+	stdin := bytes.NewBufferString(`{"type": "gator", "plugin": "bridge", "patch": "{{ fail \"not executed\" }}"}`)
+	if code := run([]string{"validate"}, stdin, stdout, &bytes.Buffer{}); code != 0 || stdout.Len() != 0 {
+		t.Fatalf("expected a valid config, got %d: %s", code, stdout)
+	}
+
+	stdin = bytes.NewBufferString(`{"type": "gator", "plugin": "bridge", "config": {}, "configFile": "config.json"}`)
+	if code := run([]string{"validate"}, stdin, stdout, &bytes.Buffer{}); code != int(types.ErrInvalidNetworkConfig) {
+		t.Fatalf("expected exit code %d, got %d", types.ErrInvalidNetworkConfig, code)
+	}
+	if !strings.Contains(stdout.String(), "config and configFile cannot be set together") {
+		t.Fatalf("expected an error about config and configFile, got %s", stdout)
+	}
+}
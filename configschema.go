@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// configProperties describe the JSON properties of [PluginConfig], for the
+// schema command. They are maintained alongside the struct, and the
+// descriptions are short forms of the doc comments of its fields.
+var configProperties = map[string]map[string]interface{}{
+	"type":                  schemaType("string", "must be gator"),
+	"plugin":                schemaType("string", "the name of the downstream plugin in CNI_PATH"),
+	"pluginByCapability":    schemaType("string", "experimental: a capability which the downstream plugin reports in its VERSION output"),
+	"config":                schemaType("object", "the base config for the downstream plugin, which may contain templates"),
+	"configFile":            schemaType("string", "a file containing the base config for the downstream plugin"),
+	"patch":                 schemaType([]string{"string", "object"}, "a templatable RFC7396 JSON merge patch applied to the base config"),
+	"commandPatches":        schemaMap("string", "templatable merge patches for a CNI_COMMAND, merged on top of patch"),
+	"resultKeys":            schemaList("string", "keys of the downstream config which are copied into the result"),
+	"mergeConfigIntoResult": schemaList("string", "keys of the downstream config which are added to the result"),
+	"resultPatch":           schemaType("string", "a templatable merge patch applied to the result of the plugin"),
+	"commandResultPatches":  schemaMap("string", "templatable result patches for a CNI_COMMAND, merged on top of resultPatch"),
+	"jsonPatch":             schemaType("string", "a templatable RFC6902 JSON patch applied to the downstream config"),
+	"finalPatch":            schemaType("string", "a templatable merge patch applied after merging with stdin"),
+	"defaults":              schemaType("object", "values which are only set in the downstream config when they are absent"),
+	"envMap":                schemaMap("string", "maps JSON pointers in the downstream config to environment variables"),
+	"envToConfig":           schemaObjectList([]string{"pointer", "envVar"}, []string{"default"}, "JSON pointers in the downstream config which are set from environment variables, with defaults"),
+	"patchFile":             schemaType("string", "a file containing the patch template, which may be gzipped"),
+	"patchURL":              schemaType("string", "an HTTP(S) URL which the patch template is fetched from"),
+	"patchURLTimeout":       schemaType("string", "the timeout for fetching patchURL, as a Go duration"),
+	"patchURLHosts":         schemaList("string", "the hosts which patchURL may be fetched from"),
+	"patchUnderPluginKey":   schemaType("boolean", "whether the patch is applied under a key named after the plugin"),
+	"networkName":           schemaType("string", "a template for the name of the network passed to the plugin"),
+	"allowedPlugins":        schemaList("string", "the plugins which may be delegated to"),
+	"exitCodeMap":           schemaMap("integer", "maps the exit codes of the plugin to the exit codes of gator"),
+	"schemaFile":            schemaType("string", "a JSON schema which the downstream config must match"),
+	"preExec":               schemaList("string", "a templatable command which is run before delegating"),
+	"postExec":              schemaList("string", "a templatable command which is run after delegating"),
+	"postExecFailure":       schemaEnum([]string{"warn", "abort"}, "what happens when postExec fails"),
+	"pluginIndex":           schemaType("integer", "the index of the conflist plugin which the patch is applied to"),
+	"pluginSelector":        schemaType("object", "keys which select the conflist plugin which the patch is applied to"),
+	"protectedKeys":         schemaList("string", "keys of stdin which the patch cannot change"),
+	"redactKeys":            schemaList("string", "keys whose values are redacted from logs and errors"),
+	"skip":                  schemaList("string", "the CNI_COMMANDs for which the plugin is not called"),
+	"identity":              schemaType("boolean", "whether the plugin is called with stdin, without any templates or patches"),
+	"cleanOnSkip":           schemaType("boolean", "whether gator's config is removed from stdin when skipping"),
+	"requireEnv":            schemaList("string", "environment variables which must not be empty"),
+	"requireEnvExempt":      schemaList("string", "the CNI_COMMANDs for which requireEnv is not checked"),
+	"prettyDownstream":      schemaType("boolean", "whether the downstream config is indented"),
+	"canonicalize":          schemaType("boolean", "whether the downstream config is serialized as RFC 8785 canonical JSON"),
+	"echoConfigOnError":     schemaType("boolean", "whether the downstream config is logged when the plugin fails"),
+	"slowThreshold":         schemaType("string", "a Go duration after which a slow plugin is logged"),
+	"timeout":               schemaType("string", "a Go duration after which the plugin is killed"),
+	"timeoutByPlugin":       schemaMap("string", "maps plugin names to a default timeout"),
+	"suppressPartialResult": schemaType("boolean", "whether output which is not a CNI error is suppressed on failure"),
+	"runAsUser":             schemaType("integer", "the uid which the plugin is run as"),
+	"runAsGroup":            schemaType("integer", "the gid which the plugin is run as"),
+	"downstreamLogLevel":    schemaType("string", "a log level which the plugin is called with in downstreamLogLevelEnv"),
+	"downstreamLogLevelEnv": schemaType("string", "the environment variable for downstreamLogLevel, which defaults to CNI_LOG_LEVEL"),
+	"envPrefixAllow":        schemaList("string", "prefixes of the environment variables passed to the plugin, besides CNI_*"),
+	"readFileRoots":         schemaList("string", "the directories which templates may read files from"),
+	"funcPlugins":           schemaList("string", "experimental: Go plugins, or directories of them, which provide template functions"),
+	"templateFuncs":         schemaList("string", "the only template functions which templates may use"),
+	"checkVersion":          schemaType("boolean", "whether the plugin is asked for its supported versions before its config is generated"),
+	"ifnameOverride":        schemaType("string", "a templatable CNI_IFNAME for the downstream plugin"),
+}
+
+// exclusiveProperties are the sets of properties which cannot be set together.
+var exclusiveProperties = [][]string{
+	{"plugin", "pluginByCapability"},
+	{"config", "configFile"},
+	{"patch", "patchURL"},
+	{"patch", "patchFile"},
+	{"patchURL", "patchFile"},
+	{"pluginIndex", "pluginSelector"},
+	{"prettyDownstream", "canonicalize"},
+	// The plugin is not resolved for a skipped command, so cleanOnSkip would
+	// not know its type
+	{"cleanOnSkip", "pluginByCapability"},
+}
+
+// dependentProperties maps properties to the properties which must also be
+// set when they are.
+var dependentProperties = map[string][]string{
+	"cleanOnSkip":           {"skip"},
+	"downstreamLogLevelEnv": {"downstreamLogLevel"},
+	"postExecFailure":       {"postExec"},
+	"requireEnvExempt":      {"requireEnv"},
+}
+
+func schemaType(typ interface{}, description string) map[string]interface{} {
+	return map[string]interface{}{"type": typ, "description": description}
+}
+
+func schemaList(typ, description string) map[string]interface{} {
+	return map[string]interface{}{
+		"type":        "array",
+		"items":       map[string]interface{}{"type": typ},
+		"description": description,
+	}
+}
+
+// schemaObjectList describes a list of objects whose properties are strings,
+// some of which are required.
+func schemaObjectList(required, optional []string, description string) map[string]interface{} {
+	props := map[string]interface{}{}
+	for _, p := range append(required, optional...) {
+		props[p] = map[string]interface{}{"type": "string"}
+	}
+	return map[string]interface{}{
+		"type": "array",
+		"items": map[string]interface{}{
+			"type":       "object",
+			"properties": props,
+			"required":   required,
+		},
+		"description": description,
+	}
+}
+
+func schemaMap(typ, description string) map[string]interface{} {
+	return map[string]interface{}{
+		"type":                 "object",
+		"additionalProperties": map[string]interface{}{"type": typ},
+		"description":          description,
+	}
+}
+
+func schemaEnum(values []string, description string) map[string]interface{} {
+	return map[string]interface{}{"type": "string", "enum": values, "description": description}
+}
+
+// configSchema returns a JSON schema describing [PluginConfig]. Any other
+// properties are allowed, since they are passed through to the plugin.
+func configSchema() map[string]interface{} {
+	exclusive := []interface{}{
+		map[string]interface{}{"anyOf": []interface{}{
+			map[string]interface{}{"required": []string{"plugin"}},
+			map[string]interface{}{"required": []string{"pluginByCapability"}},
+		}},
+	}
+	for _, props := range exclusiveProperties {
+		exclusive = append(exclusive, map[string]interface{}{
+			"not": map[string]interface{}{"required": props},
+		})
+	}
+	return map[string]interface{}{
+		"$schema":    "https://json-schema.org/draft/2020-12/schema",
+		"title":      "gator plugin configuration",
+		"type":       "object",
+		"properties": configProperties,
+		"required":   []string{"type"},
+		"allOf":      exclusive,
+
+		"dependentRequired": dependentProperties,
+	}
+}
+
+// printSchema prints [configSchema] as indented JSON.
+func printSchema(stdout io.Writer) int {
+	b, _ := json.MarshalIndent(configSchema(), "", "  ")
+	fmt.Fprintln(stdout, string(b))
+	return 0
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/containernetworking/cni/pkg/types"
+)
+
+// withDeadline returns ctx with the deadline in GATOR_DEADLINE, if it is set.
+// The deadline is either an RFC3339 time, or a Go duration from now.
+func withDeadline(ctx context.Context) (context.Context, context.CancelFunc, *types.Error) {
+	v := os.Getenv("GATOR_DEADLINE")
+	if v == "" {
+		return ctx, func() {}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		ctx, cancel := context.WithDeadline(ctx, t)
+		return ctx, cancel, nil
+	}
+	if d, err := time.ParseDuration(v); err == nil {
+		ctx, cancel := context.WithTimeout(ctx, d)
+		return ctx, cancel, nil
+	}
+	return ctx, nil, types.NewError(
+		types.ErrInvalidEnvironmentVariables,
+		"GATOR_DEADLINE must be an RFC3339 time or a duration",
+		v,
+	)
+}
+
+// deadlineError returns an error if the deadline of ctx has been exceeded, or
+// nil otherwise. step is what gator was doing, for the error.
+func deadlineError(ctx context.Context, step string) *types.Error {
+	if ctx.Err() == nil {
+		return nil
+	}
+	return types.NewError(
+		ErrDeadlineExceeded,
+		fmt.Sprintf("GATOR_DEADLINE exceeded while %s", step),
+		ctx.Err().Error(),
+	)
+}
+
+// parseTimeouts parses [PluginConfig.Timeout] and every entry in
+// [PluginConfig.TimeoutByPlugin], since the plugin may not be known yet.
+func (conf *PluginConfig) parseTimeouts() *types.Error {
+	var err *types.Error
+	if conf.parsedTimeout, err = parseDuration("timeout", conf.Timeout); err != nil {
+		return err
+	}
+	plugins := make([]string, 0, len(conf.TimeoutByPlugin))
+	for plugin := range conf.TimeoutByPlugin {
+		plugins = append(plugins, plugin)
+	}
+	sort.Strings(plugins)
+	conf.parsedTimeoutByPlugin = make(map[string]time.Duration, len(plugins))
+	for _, plugin := range plugins {
+		d, err := parseDuration(fmt.Sprintf("timeoutByPlugin entry for %s", plugin), conf.TimeoutByPlugin[plugin])
+		if err != nil {
+			return err
+		}
+		conf.parsedTimeoutByPlugin[plugin] = d
+	}
+	return nil
+}
+
+// timeout returns [PluginConfig.Timeout], or the entry in
+// [PluginConfig.TimeoutByPlugin] for the plugin if there is no Timeout. It is
+// zero if neither is set. They must have been parsed by
+// [PluginConfig.Validate].
+func (conf *PluginConfig) timeout() time.Duration {
+	if conf.Timeout != "" {
+		return conf.parsedTimeout
+	}
+	return conf.parsedTimeoutByPlugin[conf.Plugin]
+}
+
+// withTimeout returns ctx with the timeout, unless it is zero.
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// timeoutError returns an error if the plugin was killed because it took
+// longer than timeout, or nil otherwise.
+func timeoutError(ctx context.Context, plugin string, timeout time.Duration) *types.Error {
+	if ctx.Err() == nil {
+		return nil
+	}
+	return types.NewError(
+		ErrTimeoutExceeded,
+		fmt.Sprintf("plugin %s exceeded its timeout of %s", plugin, timeout),
+		ctx.Err().Error(),
+	)
+}
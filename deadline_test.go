@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/containernetworking/cni/pkg/types"
+)
+
+func TestRunDeadline(t *testing.T) {
+	dir := t.TempDir()
+	writeFakePlugin(t, dir, "slow", `exec sleep 5`)
+	t.Setenv("CNI_PATH", dir)
+	t.Setenv("GATOR_DEADLINE", "100ms")
+
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	start := time.Now()
+	code := run(nil, bytes.NewBufferString(`{"type": "gator", "plugin": "slow"}`), stdout, stderr)
+	if code != ErrDeadlineExceeded {
+		t.Fatalf("expected exit code %d, got %d: %s", ErrDeadlineExceeded, code, stderr)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("expected the plugin to be killed at the deadline, took %s", elapsed)
+	}
+	if !strings.Contains(stdout.String(), "GATOR_DEADLINE exceeded while delegating") {
+		t.Fatalf("expected a deadline error, got: %s", stdout)
+	}
+
+	t.Setenv("GATOR_DEADLINE", time.Now().Add(-time.Minute).Format(time.RFC3339))
+	if code := run(nil, bytes.NewBufferString(`{"type": "gator", "plugin": "slow"}`), stdout, stderr); code != ErrDeadlineExceeded {
+		t.Fatalf("expected exit code %d for a past deadline, got %d", ErrDeadlineExceeded, code)
+	}
+
+	t.Setenv("GATOR_DEADLINE", "soon")
+	if code := run(nil, bytes.NewBufferString(`{}`), stdout, stderr); code != int(types.ErrInvalidEnvironmentVariables) {
+		t.Fatalf("expected exit code 4 for an invalid deadline, got %d", code)
+	}
+}
+
+func TestPluginConfigTimeout(t *testing.T) {
+	table := map[string]string{"dhcp": "30s", "bridge": "2s"}
+	tests := []struct {
+		conf PluginConfig
+		want time.Duration
+	}{
+		{PluginConfig{Plugin: "dhcp", TimeoutByPlugin: table}, 30 * time.Second},
+		{PluginConfig{Plugin: "bridge", TimeoutByPlugin: table}, 2 * time.Second},
+		{PluginConfig{Plugin: "dhcp", TimeoutByPlugin: table, Timeout: "5s"}, 5 * time.Second},
+		{PluginConfig{Plugin: "macvlan", TimeoutByPlugin: table}, 0},
+	}
+	for _, tt := range tests {
+		if err := tt.conf.Validate(); err != nil {
+			t.Fatal(err)
+		}
+		if got := tt.conf.timeout(); got != tt.want {
+			t.Errorf("%s: expected %s, got %s", tt.conf.Plugin, tt.want, got)
+		}
+	}
+
+	// Every entry is checked, not only the one for the plugin
+	invalid := []PluginConfig{
+		{Plugin: "dhcp", Timeout: "soon"},
+		{Plugin: "bridge", TimeoutByPlugin: map[string]string{"dhcp": "soon"}},
+	}
+	for _, conf := range invalid {
+		if err := conf.Validate(); err == nil || err.Code != types.ErrInvalidNetworkConfig {
+			t.Errorf("expected an invalid timeout error, got %v", err)
+		}
+	}
+}
+
+func TestRunInvalidTimeoutBeforePreExec(t *testing.T) {
+	dir := t.TempDir()
+	ran := filepath.Join(dir, "ran")
+	writeFakePlugin(t, dir, "dhcp", `echo '{}'`)
+	t.Setenv("CNI_PATH", dir)
+
+	stdin := `{"type": "gator", "plugin": "dhcp", "timeout": "soon", "preExec": ["touch", "` + ran + `"]}`
+	if code := run(nil, bytes.NewBufferString(stdin), &bytes.Buffer{}, &bytes.Buffer{}); code != int(types.ErrInvalidNetworkConfig) {
+		t.Fatalf("expected exit code %d, got %d", types.ErrInvalidNetworkConfig, code)
+	}
+	if _, err := os.Stat(ran); err == nil {
+		t.Fatal("expected preExec not to run with an invalid timeout")
+	}
+}
+
+func TestRunTimeoutByPlugin(t *testing.T) {
+	dir := t.TempDir()
+	writeFakePlugin(t, dir, "dhcp", `sleep 0.2; echo '{}'`)
+	t.Setenv("CNI_PATH", dir)
+	t.Setenv("CNI_COMMAND", "ADD")
+
+	// dhcp gets its longer timeout from the table, rather than the default
+	stdin := `{"type": "gator", "plugin": "dhcp", "timeoutByPlugin": {"dhcp": "5s", "bridge": "50ms"}}`
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	if code := run(nil, bytes.NewBufferString(stdin), stdout, stderr); code != 0 {
+		t.Fatalf("expected exit code 0, got %d: %s", code, stdout)
+	}
+
+	stdin = `{"type": "gator", "plugin": "dhcp", "timeout": "50ms", "timeoutByPlugin": {"dhcp": "5s"}}`
+	stdout = &bytes.Buffer{}
+	if code := run(nil, bytes.NewBufferString(stdin), stdout, stderr); code != ErrTimeoutExceeded {
+		t.Fatalf("expected exit code %d when timeout overrides the table, got %d: %s", ErrTimeoutExceeded, code, stdout)
+	}
+	if !strings.Contains(stdout.String(), "exceeded its timeout of 50ms") {
+		t.Fatalf("expected a timeout error, got %s", stdout)
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// decodeConf decodes stdin into its top-level values. Each value is decoded as
+// a plain interface for the template data, and only gator's own keys are also
+// decoded into the PluginConfig, so large values which gator does not use
+// itself, such as the prevResult, are not decoded twice. gator's keys are
+// decoded in the order they appear in stdin, so that, as with [json.Unmarshal],
+// the last of several keys which differ only in case always wins.
+func decodeConf(stdin []byte) (*PluginConfig, error) {
+	dec := json.NewDecoder(bytes.NewReader(stdin))
+	if tok, err := dec.Token(); err != nil {
+		return nil, err
+	} else if tok != json.Delim('{') {
+		return nil, fmt.Errorf("stdin must be a JSON object")
+	}
+
+	data := map[string]interface{}{}
+	own := &bytes.Buffer{}
+	own.WriteByte('{')
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		key := tok.(string)
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			return nil, err
+		}
+		var v interface{}
+		if err := json.Unmarshal(raw, &v); err != nil {
+			return nil, err
+		}
+		data[key] = v
+
+		if !isMetaKey(key) {
+			continue
+		}
+		if own.Len() > 1 {
+			own.WriteByte(',')
+		}
+		k, _ := json.Marshal(key)
+		own.Write(k)
+		own.WriteByte(':')
+		own.Write(raw)
+	}
+	if _, err := dec.Token(); err != nil {
+		return nil, err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, fmt.Errorf("stdin must contain a single JSON object")
+	}
+	own.WriteByte('}')
+
+	conf := &PluginConfig{stdin: stdin, data: data}
+	if err := json.Unmarshal(own.Bytes(), conf); err != nil {
+		return nil, err
+	}
+	return conf, nil
+}
+
+// isMetaKey returns true if key is one of [metaKeys]. Like [json.Unmarshal],
+// keys are matched case-insensitively.
+func isMetaKey(key string) bool {
+	for _, k := range metaKeys {
+		if strings.EqualFold(k, key) {
+			return true
+		}
+	}
+	return false
+}
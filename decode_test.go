@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+)
+
+func TestDecodeConf(t *testing.T) {
+	conf, err := decodeConf([]byte(`{"type": "gator", "Plugin": "debug", "config": {"a": "<{{ .b }}>"}, "b": [1, 2]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf.Plugin != "debug" {
+		t.Fatalf("expected keys to match case-insensitively, got plugin %q", conf.Plugin)
+	}
+	if got, want := string(*conf.Config), `{"a": "<{{ .b }}>"}`; got != want {
+		t.Fatalf("expected config to be kept as is: got %s, want %s", got, want)
+	}
+	if got := fmt.Sprint(conf.data["type"], conf.data["b"]); got != "gator[1 2]" {
+		t.Fatalf("unexpected data: %v", conf.data)
+	}
+
+	for i := 0; i < 20; i++ {
+		conf, err := decodeConf([]byte(`{"type": "gator", "plugin": "a", "Plugin": "b", "PLUGIN": "c"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if conf.Plugin != "c" {
+			t.Fatalf("expected the last of the case-variant keys to win, got plugin %q", conf.Plugin)
+		}
+	}
+
+	invalid := []string{``, `null`, `[]`, `{"a": }`, `{} {}`, `{}]`, `{"skip": "ADD"}`}
+	for _, stdin := range invalid {
+		if _, err := decodeConf([]byte(stdin)); err == nil {
+			t.Errorf("expected error decoding %q", stdin)
+		}
+	}
+}
+
+// largePrevResult returns stdin with a prevResult which has n interfaces, each
+// with an IP and a route.
+func largePrevResult(n int) []byte {
+	interfaces, ips, routes := []interface{}{}, []interface{}{}, []interface{}{}
+	for i := 0; i < n; i++ {
+		interfaces = append(interfaces, map[string]interface{}{
+			"name": fmt.Sprintf("eth%d", i),
+			"mac":  fmt.Sprintf("02:00:00:00:%02x:%02x", i/256, i%256),
+		})
+		ips = append(ips, map[string]interface{}{
+			"interface": i,
+			"address":   fmt.Sprintf("10.%d.%d.2/24", i/256, i%256),
+			"gateway":   fmt.Sprintf("10.%d.%d.1", i/256, i%256),
+		})
+		routes = append(routes, map[string]interface{}{
+			"dst": fmt.Sprintf("10.%d.%d.0/24", i/256, i%256),
+			"gw":  fmt.Sprintf("10.%d.%d.1", i/256, i%256),
+		})
+	}
+	stdin, _ := json.Marshal(map[string]interface{}{
+		"type":   "gator",
+		"plugin": "debug",
+		"patch":  `{"gw": "{{ (index .prevResult.ips 0).gateway }}"}`,
+		"prevResult": map[string]interface{}{
+			"cniVersion": "1.0.0",
+			"interfaces": interfaces,
+			"ips":        ips,
+			"routes":     routes,
+		},
+	})
+	return stdin
+}
+
+func BenchmarkParseConfLargePrevResult(b *testing.B) {
+	stdin := largePrevResult(1000)
+	b.SetBytes(int64(len(stdin)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := parseConf(stdin); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestTemplateDataIsNotReparsed(t *testing.T) {
+	conf, err := parseConf(largePrevResult(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// If templateData parsed stdin again, it would not see this
+	conf.data["decoded"] = true
+	data := templateData(conf)
+	if data["decoded"] != true {
+		t.Fatal("expected templateData to use the decoded stdin")
+	}
+	if data["Raw"] != string(conf.stdin) {
+		t.Fatal("expected Raw to be stdin")
+	}
+	if _, ok := conf.data["Raw"]; ok {
+		t.Fatal("expected templateData not to modify the decoded stdin")
+	}
+}
+
+func BenchmarkTemplateDataLargePrevResult(b *testing.B) {
+	conf, err := parseConf(largePrevResult(1000))
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		templateData(conf)
+	}
+}
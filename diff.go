@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// diffConfigs returns a line-based diff between the indented forms of the JSON
+// documents before and after. Each line of the result is prefixed with "-" if
+// it was removed, "+" if it was added, or " " if it is unchanged.
+func diffConfigs(before, after []byte) (string, error) {
+	a, err := indentSorted(before)
+	if err != nil {
+		return "", err
+	}
+	b, err := indentSorted(after)
+	if err != nil {
+		return "", err
+	}
+
+	out := &strings.Builder{}
+	out.WriteString("--- stdin\n+++ downstream\n")
+	for _, line := range diffLines(a, b) {
+		out.WriteString(line)
+		out.WriteByte('\n')
+	}
+	return out.String(), nil
+}
+
+// indentSorted returns the lines of doc indented, with its keys sorted so that
+// documents can be compared regardless of their original key order.
+func indentSorted(doc []byte) ([]string, error) {
+	var v interface{}
+	if err := json.Unmarshal(doc, &v); err != nil {
+		return nil, err
+	}
+	indented, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return strings.Split(string(indented), "\n"), nil
+}
+
+// maxDiffCells is the largest table of common subsequence lengths which
+// diffLines builds, which is 32MiB of ints. Larger diffs are not minimal.
+const maxDiffCells = 1 << 22
+
+// diffLines returns the prefixed lines of a diff from a to b. Lines which a
+// and b start and end with are unchanged, and the lines between them are
+// diffed based on their longest common subsequence. If that would take more
+// than [maxDiffCells], all of those lines from a are removed and all of those
+// from b are added instead.
+func diffLines(a, b []string) []string {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	lines := []string{}
+	for _, line := range a[:prefix] {
+		lines = append(lines, " "+line)
+	}
+	lines = append(lines, diffMiddle(a[prefix:len(a)-suffix], b[prefix:len(b)-suffix])...)
+	for _, line := range a[len(a)-suffix:] {
+		lines = append(lines, " "+line)
+	}
+	return lines
+}
+
+// diffMiddle returns the prefixed lines of a diff from a to b for
+// [diffLines].
+func diffMiddle(a, b []string) []string {
+	lines := []string{}
+	if (len(a)+1)*(len(b)+1) > maxDiffCells {
+		for _, line := range a {
+			lines = append(lines, "-"+line)
+		}
+		for _, line := range b {
+			lines = append(lines, "+"+line)
+		}
+		return lines
+	}
+
+	// lcs[i][j] is the length of the longest common subsequence of a[i:] and
+	// b[j:].
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			lines = append(lines, " "+a[i])
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			lines = append(lines, "-"+a[i])
+			i++
+		default:
+			lines = append(lines, "+"+b[j])
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		lines = append(lines, "-"+a[i])
+	}
+	for ; j < len(b); j++ {
+		lines = append(lines, "+"+b[j])
+	}
+	return lines
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestRunDiff(t *testing.T) {
+	stdin, err := os.Open("testdata/route-override.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stdin.Close()
+
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	args := []string{"--diff", "--prev-result", "testdata/prevresult.json"}
+	if code := run(args, stdin, stdout, stderr); code != 0 {
+		t.Fatalf("expected exit code 0, got %d: %s", code, stderr)
+	}
+	if stdout.Len() != 0 {
+		t.Fatalf("expected no output on stdout, got: %s", stdout)
+	}
+
+	lines := strings.Split(stderr.String(), "\n")
+	if lines[0] != "--- stdin" || lines[1] != "+++ downstream" {
+		t.Fatalf("unexpected diff header:\n%s", stderr)
+	}
+	if !strings.Contains(stderr.String(), "\n+  \"addroutes\": [\n") {
+		t.Fatalf("expected diff to add addroutes:\n%s", stderr)
+	}
+}
+
+func TestRunDiffRedacted(t *testing.T) {
+	stdin := `{"cniVersion": "1.0.0", "type": "gator", "plugin": "debug", "redactKeys": ["password"], "password": "s3cret", "patch": "{\"password\": \"{{ .password }}x\", \"note\": \"uses {{ .password }}\"}"}`
+	stderr := &bytes.Buffer{}
+	if code := run([]string{"--diff"}, bytes.NewBufferString(stdin), &bytes.Buffer{}, stderr); code != 0 {
+		t.Fatalf("expected exit code 0, got %d: %s", code, stderr)
+	}
+	if strings.Contains(stderr.String(), "s3cret") {
+		t.Fatalf("diff contains the redacted value:\n%s", stderr)
+	}
+	if !strings.Contains(stderr.String(), "\n+  \"note\": \"uses ***\",\n") {
+		t.Fatalf("expected the value to be masked in other keys:\n%s", stderr)
+	}
+}
+
+func TestDiffLines(t *testing.T) {
+	a := []string{"{", `  "a": 1,`, `  "b": 2`, "}"}
+	b := []string{"{", `  "a": 1,`, `  "c": 3`, "}"}
+	want := []string{" {", `   "a": 1,`, `-  "b": 2`, `+  "c": 3`, " }"}
+	if got := diffLines(a, b); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("unexpected diff:\ngot:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestDiffLinesLarge(t *testing.T) {
+	a, b := []string{"{"}, []string{"{"}
+	for i := 0; i < 3000; i++ {
+		a = append(a, fmt.Sprintf("  %q: %d,", fmt.Sprint("a", i), i))
+		b = append(b, fmt.Sprintf("  %q: %d,", fmt.Sprint("b", i), i))
+	}
+	a, b = append(a, "}"), append(b, "}")
+
+	got := diffLines(a, b)
+	if len(got) != 2+2*3000 || got[0] != " {" || got[len(got)-1] != " }" {
+		t.Fatalf("unexpected diff of %d lines", len(got))
+	}
+	if got[1] != "-"+a[1] || got[3000] != "-"+a[3000] || got[3001] != "+"+b[1] {
+		t.Fatalf("expected every removed line before every added line, got %q, %q, %q", got[1], got[3000], got[3001])
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+
+	jsonpatch "github.com/evanphx/json-patch"
+)
+
+// b64decBytes returns the raw bytes of the standard base64 encoding s. Unlike
+// sprig's b64dec, which returns a string (and the error message when s is
+// invalid), it fails on invalid input, and the bytes can be passed to functions
+// such as hexEncode.
+func b64decBytes(s string) ([]byte, error) {
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("b64decBytes: %w", err)
+	}
+	return b, nil
+}
+
+// hexEncode returns the lowercase hex encoding of v, which may be bytes or a
+// string.
+func hexEncode(v interface{}) (string, error) {
+	switch b := v.(type) {
+	case []byte:
+		return hex.EncodeToString(b), nil
+	case string:
+		return hex.EncodeToString([]byte(b)), nil
+	default:
+		return "", fmt.Errorf("hexEncode: not bytes or a string: %v", v)
+	}
+}
+
+// toStableJSON returns v as compact JSON with the keys of objects sorted, so the
+// output is the same every time for the same value. Unlike sprig's toJson, it
+// fails if v cannot be marshaled, and does not escape HTML characters. To embed
+// it in a JSON string field, pipe it to toJson:
+//
+//	"config": {{ toStableJSON .config | toJson }}
+func toStableJSON(v interface{}) (string, error) {
+	out := &bytes.Buffer{}
+	enc := json.NewEncoder(out)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return "", fmt.Errorf("toStableJSON: %w", err)
+	}
+	return string(bytes.TrimSuffix(out.Bytes(), []byte("\n"))), nil
+}
+
+// mergeObjects returns the result of applying b to a as an RFC 7396 JSON merge
+// patch: keys of b replace those of a, objects are merged recursively, and null
+// values in b remove the key. Unlike sprig's merge, neither a nor b is
+// modified. The result is an object, so it can be piped to toJson:
+//
+//	"ipam": {{ mergeObjects .ipam .overrides | toJson }}
+func mergeObjects(a, b interface{}) (map[string]interface{}, error) {
+	original, err := json.Marshal(a)
+	if err != nil {
+		return nil, fmt.Errorf("mergeObjects: %w", err)
+	}
+	patch, err := json.Marshal(b)
+	if err != nil {
+		return nil, fmt.Errorf("mergeObjects: %w", err)
+	}
+	merged, err := jsonpatch.MergePatch(original, patch)
+	if err != nil {
+		return nil, fmt.Errorf("mergeObjects: %w", err)
+	}
+	out := map[string]interface{}{}
+	dec := json.NewDecoder(bytes.NewReader(merged))
+	dec.UseNumber()
+	if err := dec.Decode(&out); err != nil {
+		return nil, fmt.Errorf("mergeObjects: not an object: %w", err)
+	}
+	return out, nil
+}
+
+// jsonNull returns the JSON literal null. It is emitted unquoted, such as
+// "fieldToRemove": {{ jsonNull }}, so that a merge patch removes the field.
+func jsonNull() string {
+	return "null"
+}
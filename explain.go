@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// stageTimings is a [sdktrace.SpanProcessor] which records how long each
+// stage of an invocation took, for --explain. Stages are recorded in the order
+// in which they end.
+type stageTimings struct {
+	mu     sync.Mutex
+	stages []stageTiming
+}
+
+// stageTiming is the duration of a single stage, which is a span.
+type stageTiming struct {
+	name     string
+	duration time.Duration
+}
+
+func (t *stageTimings) OnStart(context.Context, sdktrace.ReadWriteSpan) {}
+
+func (t *stageTimings) OnEnd(s sdktrace.ReadOnlySpan) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.stages = append(t.stages, stageTiming{s.Name(), s.EndTime().Sub(s.StartTime())})
+}
+
+func (t *stageTimings) Shutdown(context.Context) error { return nil }
+
+func (t *stageTimings) ForceFlush(context.Context) error { return nil }
+
+// print writes a line with the duration of each stage to w. The stage for the
+// whole invocation is the total.
+func (t *stageTimings) print(w io.Writer) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, s := range t.stages {
+		name := s.name
+		if name == "gator" {
+			name = "total"
+		}
+		fmt.Fprintf(w, "timing: %s %s\n", name, s.duration)
+	}
+}
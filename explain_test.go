@@ -0,0 +1,35 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestRunExplainTimings(t *testing.T) {
+	dir := t.TempDir()
+	writeFakePlugin(t, dir, "ok", `echo '{}'`)
+	t.Setenv("CNI_PATH", dir)
+	t.Setenv("CNI_COMMAND", "ADD")
+
+	stdin := bytes.NewBufferString(`{"type": "gator", "plugin": "ok", "patch": "{\"a\": \"{{.type}}\"}"}`)
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	if code := run([]string{"--explain"}, stdin, stdout, stderr); code != 0 {
+		t.Fatalf("expected exit code 0, got %d: %s", code, stdout)
+	}
+
+	stages := map[string]bool{}
+	for _, line := range strings.Split(strings.TrimSpace(stderr.String()), "\n") {
+		if fields := strings.Fields(line); len(fields) == 3 && fields[0] == "timing:" {
+			stages[fields[1]] = true
+		}
+	}
+	for _, stage := range []string{"parse", "template", "merge", "delegate", "total"} {
+		if !stages[stage] {
+			t.Errorf("expected a timing for %s, got %s", stage, stderr)
+		}
+	}
+	if stdout.String() != "{}\n" {
+		t.Errorf("expected the result on stdout to be unaffected, got %q", stdout)
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"time"
+
+	"github.com/containernetworking/cni/pkg/types"
+)
+
+const (
+	// defaultPatchURLTimeout is used when [PluginConfig.PatchURLTimeout] is not
+	// set.
+	defaultPatchURLTimeout = 5 * time.Second
+
+	// maxPatchURLBytes is the largest patch template which will be fetched.
+	maxPatchURLBytes = 1 << 20
+)
+
+// fetchPatch fetches the patch template from [PluginConfig.PatchURL]. Nothing
+// is cached, so the template is fetched on every invocation.
+func fetchPatch(ctx context.Context, conf *PluginConfig) (string, *types.Error) {
+	u, err := url.Parse(conf.PatchURL)
+	if err != nil {
+		return "", types.NewError(
+			ErrPatchFetchFailed,
+			"invalid patchURL",
+			err.Error(),
+		)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", types.NewError(
+			ErrPatchFetchFailed,
+			"patchURL must be http or https",
+			conf.PatchURL,
+		)
+	}
+	if err := checkPatchURLHost(u, conf.PatchURLHosts); err != nil {
+		return "", types.NewError(
+			ErrPatchFetchFailed,
+			err.Error(),
+			fmt.Sprintf("allowed: %v", conf.PatchURLHosts),
+		)
+	}
+
+	timeout := conf.patchURLTimeout
+	if timeout == 0 {
+		timeout = defaultPatchURLTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return "", types.NewError(ErrPatchFetchFailed, "failed to fetch patchURL", err.Error())
+	}
+	resp, err := patchClient(conf.PatchURLHosts).Do(req)
+	if err != nil {
+		return "", types.NewError(ErrPatchFetchFailed, "failed to fetch patchURL", err.Error())
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", types.NewError(
+			ErrPatchFetchFailed,
+			"failed to fetch patchURL",
+			fmt.Sprintf("unexpected status: %s", resp.Status),
+		)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxPatchURLBytes+1))
+	if err != nil {
+		return "", types.NewError(ErrPatchFetchFailed, "failed to fetch patchURL", err.Error())
+	}
+	if len(body) > maxPatchURLBytes {
+		return "", types.NewError(
+			ErrPatchFetchFailed,
+			"failed to fetch patchURL",
+			fmt.Sprintf("patch is larger than %d bytes", maxPatchURLBytes),
+		)
+	}
+	return string(body), nil
+}
+
+// maxPatchURLRedirects is how many redirects are followed when fetching
+// [PluginConfig.PatchURL], which is the same as the default client.
+const maxPatchURLRedirects = 10
+
+// patchClient returns the client which fetches [PluginConfig.PatchURL]. Each
+// redirect is checked against hosts in the same way as the PatchURL itself, so
+// that an allowed host cannot redirect to one which is not allowed.
+func patchClient(hosts []string) *http.Client {
+	return &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= maxPatchURLRedirects {
+				return fmt.Errorf("stopped after %d redirects", maxPatchURLRedirects)
+			}
+			if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+				return fmt.Errorf("redirect must be http or https: %s", req.URL)
+			}
+			return checkPatchURLHost(req.URL, hosts)
+		},
+	}
+}
+
+// checkPatchURLHost returns an error if hosts is not empty and does not include
+// the host of u.
+func checkPatchURLHost(u *url.URL, hosts []string) error {
+	if len(hosts) > 0 && !slices.Contains(hosts, u.Hostname()) {
+		return fmt.Errorf("patchURL host is not allowed: %s", u.Hostname())
+	}
+	return nil
+}
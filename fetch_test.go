@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestParseConfPatchURL(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/patch.json" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, `{"fetched": "{{ .plugin }}"}`)
+	}))
+	defer srv.Close()
+
+	t.Run("template is fetched", func(t *testing.T) {
+		stdin := fmt.Sprintf(`{"type": "gator", "plugin": "debug", "patchURL": "%s/patch.json", "patchURLHosts": ["127.0.0.1"]}`, srv.URL)
+		conf, err := parseConf([]byte(stdin))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := string(conf.downstreamConfig); got != `{"fetched":"debug","type":"debug"}` {
+			t.Fatalf("unexpected downstream config: %s", got)
+		}
+	})
+
+	invalid := map[string]string{
+		"disallowed host": fmt.Sprintf(`{"type": "gator", "plugin": "debug", "patchURL": "%s/patch.json", "patchURLHosts": ["config.example.com"]}`, srv.URL),
+		"not found":       fmt.Sprintf(`{"type": "gator", "plugin": "debug", "patchURL": "%s/missing.json"}`, srv.URL),
+		"bad scheme":      `{"type": "gator", "plugin": "debug", "patchURL": "file:///etc/passwd"}`,
+	}
+	for name, stdin := range invalid {
+		t.Run(name, func(t *testing.T) {
+			_, err := parseConf([]byte(stdin))
+			if err == nil || err.Code != ErrPatchFetchFailed {
+				t.Fatalf("expected code %d, got %v", ErrPatchFetchFailed, err)
+			}
+		})
+	}
+
+	t.Run("conflicts with patch", func(t *testing.T) {
+		stdin := fmt.Sprintf(`{"type": "gator", "plugin": "debug", "patch": "{}", "patchURL": "%s/patch.json"}`, srv.URL)
+		if _, err := parseConf([]byte(stdin)); err == nil {
+			t.Fatal("expected error when patch and patchURL are both set")
+		}
+	})
+}
+
+func TestParseConfPatchURLRedirect(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/redirect" {
+			// Redirect to the same server by another name
+			target := strings.Replace("http://"+r.Host, "127.0.0.1", "localhost", 1) + "/patch.json"
+			http.Redirect(w, r, target, http.StatusFound)
+			return
+		}
+		fmt.Fprint(w, `{"fetched": true}`)
+	}))
+	defer srv.Close()
+
+	stdin := fmt.Sprintf(`{"type": "gator", "plugin": "debug", "patchURL": "%s/redirect", "patchURLHosts": ["127.0.0.1"]}`, srv.URL)
+	if _, err := parseConf([]byte(stdin)); err == nil || err.Code != ErrPatchFetchFailed {
+		t.Fatalf("expected a redirect to a disallowed host to fail with code %d, got %v", ErrPatchFetchFailed, err)
+	}
+
+	stdin = fmt.Sprintf(`{"type": "gator", "plugin": "debug", "patchURL": "%s/redirect", "patchURLHosts": ["127.0.0.1", "localhost"]}`, srv.URL)
+	conf, err := parseConf([]byte(stdin))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(conf.downstreamConfig); got != `{"fetched":true,"type":"debug"}` {
+		t.Fatalf("unexpected downstream config: %s", got)
+	}
+}
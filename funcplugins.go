@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"plugin"
+	"text/template"
+
+	"github.com/containernetworking/cni/pkg/types"
+)
+
+// funcPluginSymbol is the name of the symbol which function plugins export.
+const funcPluginSymbol = "Funcs"
+
+// FuncMapProvider is implemented by the Funcs symbol which function plugins
+// export, such as:
+//
+//	type funcs struct{}
+//
+//	func (funcs) FuncMap() template.FuncMap {
+//		return template.FuncMap{"shout": strings.ToUpper}
+//	}
+//
+//	var Funcs funcs
+//
+// Plugins are built with go build -buildmode=plugin, using the same version of
+// Go as gator.
+type FuncMapProvider interface {
+	FuncMap() template.FuncMap
+}
+
+// loadFuncPlugins returns the template functions from the Go plugins in
+// [PluginConfig.FuncPlugins], which are either .so files or directories of
+// them. Plugins may not redefine functions from sprig, gator or each other.
+// Since gator runs once per invocation, changes to the plugins are picked up
+// by the next invocation.
+func loadFuncPlugins(paths []string) (template.FuncMap, *types.Error) {
+	if len(paths) == 0 {
+		return nil, nil
+	}
+	if !experimentalEnabled("funcPlugins") {
+		return nil, types.NewError(
+			types.ErrInvalidNetworkConfig,
+			"funcPlugins is experimental",
+			"set GATOR_EXPERIMENTAL=funcPlugins to enable it",
+		)
+	}
+
+	files := []string{}
+	for _, p := range paths {
+		s, err := os.Stat(p)
+		if err != nil {
+			return nil, funcPluginError(p, err)
+		}
+		if !s.IsDir() {
+			files = append(files, p)
+			continue
+		}
+		// Glob only fails for a malformed pattern, which this is not
+		matches, _ := filepath.Glob(filepath.Join(p, "*.so"))
+		files = append(files, matches...)
+	}
+
+	builtin := funcMap(nil, funcOptions{})
+	funcs := template.FuncMap{}
+	for _, file := range files {
+		provided, err := loadFuncPlugin(file)
+		if err != nil {
+			return nil, funcPluginError(file, err)
+		}
+		for name, f := range provided {
+			if _, ok := builtin[name]; ok {
+				return nil, funcPluginError(file, fmt.Errorf("%s is already a template function", name))
+			}
+			if _, ok := funcs[name]; ok {
+				return nil, funcPluginError(file, fmt.Errorf("%s is defined by another function plugin", name))
+			}
+			funcs[name] = f
+		}
+	}
+	return funcs, nil
+}
+
+// loadFuncPlugin opens the Go plugin at file and returns the functions from
+// its [FuncMapProvider].
+func loadFuncPlugin(file string) (template.FuncMap, error) {
+	p, err := plugin.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	sym, err := p.Lookup(funcPluginSymbol)
+	if err != nil {
+		return nil, err
+	}
+	provider, ok := sym.(FuncMapProvider)
+	if !ok {
+		return nil, fmt.Errorf("%s does not implement FuncMap() template.FuncMap", funcPluginSymbol)
+	}
+	return provider.FuncMap(), nil
+}
+
+// funcPluginError returns err as an error for the function plugin at path.
+func funcPluginError(path string, err error) *types.Error {
+	return types.NewError(
+		types.ErrInvalidNetworkConfig,
+		fmt.Sprintf("failed to load function plugin: %s", path),
+		err.Error(),
+	)
+}
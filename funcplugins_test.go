@@ -0,0 +1,56 @@
+//go:build funcplugins && cgo && (linux || darwin || freebsd)
+
+package main
+
+import (
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// buildFuncPlugin builds the function plugin in the testdata directory dir, and
+// returns the path of the .so file.
+func buildFuncPlugin(t *testing.T, dir string) string {
+	t.Helper()
+	if testing.Short() {
+		t.Skip("building function plugins is slow")
+	}
+	out := filepath.Join(t.TempDir(), filepath.Base(dir)+".so")
+	cmd := exec.Command("go", "build", "-buildmode=plugin", "-o", out, "./"+dir)
+	if b, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("failed to build %s: %v\n%s", dir, err, b)
+	}
+	return out
+}
+
+func TestFuncPlugins(t *testing.T) {
+	so := buildFuncPlugin(t, "testdata/funcplugin")
+	stdin := []byte(`{"type": "gator", "plugin": "debug", "funcPlugins": ["` + filepath.Dir(so) + `"], "templateFuncs": ["shout"], "patch": "{\"name\": \"{{ shout .plugin }}\"}", "protectedKeys": []}`)
+
+	if _, err := parseConf(stdin); err == nil {
+		t.Fatal("expected funcPlugins to require GATOR_EXPERIMENTAL")
+	}
+
+	t.Setenv("GATOR_EXPERIMENTAL", "funcPlugins")
+	conf, err := parseConf(stdin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"name":"DEBUG","type":"debug"}`; string(conf.downstreamConfig) != want {
+		t.Fatalf("expected %s, got %s", want, conf.downstreamConfig)
+	}
+}
+
+func TestFuncPluginsInvalid(t *testing.T) {
+	t.Setenv("GATOR_EXPERIMENTAL", "funcPlugins")
+	invalid := map[string]string{
+		"missing file":   filepath.Join(t.TempDir(), "missing.so"),
+		"not a plugin":   "testdata/node.json",
+		"missing symbol": buildFuncPlugin(t, "testdata/funcplugin-nosymbol"),
+	}
+	for name, path := range invalid {
+		if _, err := loadFuncPlugins([]string{path}); err == nil {
+			t.Errorf("%s: expected error loading %s", name, path)
+		}
+	}
+}
@@ -0,0 +1,339 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	sprig "github.com/Masterminds/sprig/v3"
+	"github.com/containernetworking/cni/pkg/types"
+	"github.com/google/uuid"
+)
+
+// funcOptions are the parts of the [PluginConfig] which change the template
+// functions. They are kept out of the template data, so that templates cannot
+// see them or shadow them with keys in stdin.
+type funcOptions struct {
+	// allowed is [PluginConfig.TemplateFuncs].
+	allowed []string
+
+	// readFileRoots is [PluginConfig.ReadFileRoots].
+	readFileRoots []string
+
+	// pluginFuncs are the functions loaded from [PluginConfig.FuncPlugins].
+	pluginFuncs template.FuncMap
+}
+
+// funcOptions returns the funcOptions for conf.
+func (conf *PluginConfig) funcOptions() funcOptions {
+	return funcOptions{
+		allowed:       conf.TemplateFuncs,
+		readFileRoots: conf.ReadFileRoots,
+		pluginFuncs:   conf.pluginFuncs,
+	}
+}
+
+// funcMap returns the functions available to templates which are executed
+// with data: everything from sprig, plus gator's own helpers and the
+// pluginFuncs. If opts has an allow-list, only those functions are available.
+func funcMap(data interface{}, opts funcOptions) template.FuncMap {
+	funcs := sprig.FuncMap()
+	for name, f := range gatorFuncs(data, opts) {
+		funcs[name] = f
+	}
+	for name, f := range opts.pluginFuncs {
+		funcs[name] = f
+	}
+	if len(opts.allowed) == 0 {
+		return funcs
+	}
+	filtered := template.FuncMap{}
+	for _, name := range opts.allowed {
+		if f, ok := funcs[name]; ok {
+			filtered[name] = f
+		}
+	}
+	return filtered
+}
+
+// gatorFuncs returns the template functions which are implemented by gator.
+// Some of them operate on the template data, such as the prevResult.
+func gatorFuncs(data interface{}, opts funcOptions) template.FuncMap {
+	prevResult := prevResultOf(data)
+	files := fileReader{roots: opts.readFileRoots}
+	return template.FuncMap{
+		"cidrSubnet":     cidrSubnet,
+		"cidrHost":       cidrHost,
+		"cidrUsableHost": cidrUsableHost,
+		"cidrHosts":      cidrHosts,
+		"cidrNetwork":    cidrNetwork,
+		"cidrBroadcast":  cidrBroadcast,
+		"isIPv4":         isIPv4,
+		"isIPv6":         isIPv6,
+		"ipInCIDR":       ipInCIDR,
+		"ptrName":        ptrName,
+		"byFamily":       byFamily,
+		"defaultGateway": prevResult.defaultGateway,
+		"routeFor":       prevResult.routeFor,
+		"callID":         callID,
+		"gatorVersion":   gatorVersion,
+		"mtuMinus":       mtuMinus,
+		"clamp":          clamp,
+		"uniqueRoutes":   uniqueRoutes,
+		"zeroPad":        zeroPad,
+
+		"durationSeconds": durationSeconds,
+
+		"sanitizeIfname": sanitizeIfname,
+
+		"argOr":   argOr,
+		"argInt":  argInt,
+		"argBool": argBool,
+
+		"sandboxInterface": prevResult.sandboxInterface,
+		"sandboxIfname":    prevResult.sandboxIfname,
+		"sandboxMAC":       prevResult.sandboxMAC,
+
+		"ipCount":        prevResult.ipCount,
+		"interfaceCount": prevResult.interfaceCount,
+		"isDualStack":    prevResult.isDualStack,
+
+		"hostNameservers":     files.hostNameservers,
+		"mustHostNameservers": files.mustHostNameservers,
+		"netnsInode":          netnsInode,
+		"pluginExists":        pluginExists,
+		"jsonFile":            files.jsonFile,
+
+		"b64decBytes": b64decBytes,
+		"hexEncode":   hexEncode,
+
+		"toStableJSON": toStableJSON,
+		"mergeObjects": mergeObjects,
+		"jsonNull":     jsonNull,
+
+		"tpl": tplRenderer{data: data, opts: opts}.tpl,
+		"now": now,
+
+		"parseMAC":     parseMAC,
+		"normalizeMAC": normalizeMAC,
+		"localMAC":     localMAC,
+	}
+}
+
+// mtuMinus returns base minus overhead, such as the MTU of an overlay network
+// on an interface. Both may be any kind of template integer, including numbers
+// from JSON, and the result is an int so it can be emitted as a JSON number.
+func mtuMinus(base, overhead interface{}) (int, error) {
+	b, err := toInt(base)
+	if err != nil {
+		return 0, fmt.Errorf("mtuMinus: invalid base: %w", err)
+	}
+	o, err := toInt(overhead)
+	if err != nil {
+		return 0, fmt.Errorf("mtuMinus: invalid overhead: %w", err)
+	}
+	if o < 0 || o >= b {
+		return 0, fmt.Errorf("mtuMinus: overhead %d must be between 0 and the base %d", o, b)
+	}
+	return b - o, nil
+}
+
+// clamp returns value bounded to the range from min to max, inclusive, such as
+// to keep a computed MTU within what an interface supports. Like [mtuMinus],
+// the arguments may be any kind of template integer.
+func clamp(value, min, max interface{}) (int, error) {
+	v, err := toInt(value)
+	if err != nil {
+		return 0, fmt.Errorf("clamp: invalid value: %w", err)
+	}
+	lo, err := toInt(min)
+	if err != nil {
+		return 0, fmt.Errorf("clamp: invalid min: %w", err)
+	}
+	hi, err := toInt(max)
+	if err != nil {
+		return 0, fmt.Errorf("clamp: invalid max: %w", err)
+	}
+	if lo > hi {
+		return 0, fmt.Errorf("clamp: min %d is greater than max %d", lo, hi)
+	}
+	if v < lo {
+		return lo, nil
+	}
+	if v > hi {
+		return hi, nil
+	}
+	return v, nil
+}
+
+// zeroPad returns n as a string, padded with leading zeros to at least width
+// digits. For example, zeroPad 7 4 is "0007".
+func zeroPad(n, width interface{}) (string, error) {
+	i, err := toInt(n)
+	if err != nil {
+		return "", fmt.Errorf("zeroPad: invalid number: %w", err)
+	}
+	w, err := toInt(width)
+	if err != nil || w < 0 {
+		return "", fmt.Errorf("zeroPad: invalid width: %v", width)
+	}
+	return fmt.Sprintf("%0*d", w, i), nil
+}
+
+// durationSeconds returns the Go duration s, such as "2m", as a whole number of
+// seconds, for plugins which are configured with integer seconds. Fractions of
+// a second are truncated.
+func durationSeconds(s string) (int, error) {
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("durationSeconds: %w", err)
+	}
+	return int(d / time.Second), nil
+}
+
+// maxTplDepth is how deeply tpl may be nested, so that a template which renders
+// itself cannot recurse forever.
+const maxTplDepth = 10
+
+// tplRenderer renders nested templates for tpl, at a depth of nesting.
+type tplRenderer struct {
+	data  interface{}
+	opts  funcOptions
+	depth int
+}
+
+// tpl executes text as a template with the same data and functions as the
+// template which called it, such as when a value in stdin is itself a
+// template.
+func (r tplRenderer) tpl(text string) (string, error) {
+	if r.depth >= maxTplDepth {
+		return "", fmt.Errorf("tpl: templates are nested more than %d deep", maxTplDepth)
+	}
+	nested := tplRenderer{data: r.data, opts: r.opts, depth: r.depth + 1}
+	tmpl, err := template.New("tpl").
+		Funcs(funcMap(r.data, r.opts)).
+		Funcs(template.FuncMap{"tpl": nested.tpl}).
+		Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("tpl: %w", err)
+	}
+	out := &strings.Builder{}
+	if err := tmpl.Execute(out, r.data); err != nil {
+		return "", fmt.Errorf("tpl: %w", err)
+	}
+	return strings.ReplaceAll(out.String(), "<no value>", ""), nil
+}
+
+// funcDescriptions are one-line descriptions of each of [gatorFuncs], for
+// list-funcs.
+var funcDescriptions = map[string]string{
+	"cidrSubnet":     "returns the Nth subnet of a CIDR with a new prefix length",
+	"cidrHost":       "returns the Nth address of a CIDR, where the network address is 0",
+	"cidrUsableHost": "returns the Nth usable host of a CIDR, excluding network and broadcast",
+	"cidrHosts":      "returns up to a limit of the usable hosts of a CIDR",
+	"cidrNetwork":    "returns the network address of a CIDR",
+	"cidrBroadcast":  "returns the broadcast address of an IPv4 CIDR",
+	"isIPv4":         "returns whether an IP address or CIDR is IPv4",
+	"isIPv6":         "returns whether an IP address or CIDR is IPv6",
+	"ipInCIDR":       "returns whether an IP address is within a CIDR",
+	"ptrName":        "returns the reverse-DNS name of an IP address",
+	"byFamily":       "returns the ips of a CNI result which are of a family (4 or 6)",
+	"defaultGateway": "returns the gateway of the default route for a family in prevResult",
+	"routeFor":       "returns the most specific prevResult route for a destination",
+	"callID":         "returns a UUID which is the same for the whole invocation",
+	"gatorVersion":   "returns the version of gator",
+	"mtuMinus":       "returns a base MTU minus an overhead",
+	"clamp":          "returns an integer bounded to a minimum and maximum",
+	"uniqueRoutes":   "returns a list of routes without duplicate dst and gw",
+	"zeroPad":        "returns an integer as a string zero-padded to a width",
+
+	"durationSeconds": "returns a Go duration as a whole number of seconds",
+
+	"sanitizeIfname": "returns a string as a valid Linux interface name",
+
+	"argOr":   "returns a CNI_ARGS value, or a default if it is not set",
+	"argInt":  "returns a CNI_ARGS value as an integer, with an optional default",
+	"argBool": "returns a CNI_ARGS value as a boolean, with an optional default",
+
+	"sandboxInterface": "returns the prevResult interface which has a sandbox",
+	"sandboxIfname":    "returns the name of the prevResult interface which has a sandbox",
+	"sandboxMAC":       "returns the MAC of the prevResult interface which has a sandbox",
+
+	"ipCount":        "returns the number of ips in prevResult",
+	"interfaceCount": "returns the number of interfaces in prevResult",
+	"isDualStack":    "returns whether prevResult has both IPv4 and IPv6 addresses",
+
+	"hostNameservers":     "returns the nameservers in the host's resolv.conf, or an empty list",
+	"mustHostNameservers": "returns the nameservers in the host's resolv.conf, or fails",
+	"netnsInode":          "returns the inode number of CNI_NETNS as a string",
+	"pluginExists":        "returns whether a plugin is an executable in CNI_PATH",
+	"jsonFile":            "returns the value at a JSON pointer in a file within readFileRoots",
+
+	"b64decBytes": "returns the raw bytes of a base64 string",
+	"hexEncode":   "returns the hex encoding of bytes or a string",
+
+	"toStableJSON": "returns a value as compact JSON with sorted keys",
+	"mergeObjects": "returns an object merged with another as a JSON merge patch",
+	"jsonNull":     "returns the JSON literal null, to remove a field with a merge patch",
+
+	"tpl": "renders a string as a template with the same data",
+	"now": "returns the current time, or GATOR_FAKE_TIME if it is set",
+
+	"parseMAC":     "parses a MAC address",
+	"normalizeMAC": "returns a MAC address as colon-separated lowercase hex",
+	"localMAC":     "returns a locally administered MAC address derived from a seed",
+}
+
+// gatorVersion returns [Version], so configs can record which gator generated
+// them.
+func gatorVersion() string {
+	return Version
+}
+
+// callID returns a random UUID which is generated once per invocation, so
+// every template evaluation sees the same value.
+var callID = sync.OnceValue(uuid.NewString)
+
+// toInt converts a template value to an int. Integers in templates may be Go
+// ints (from literals), float64 or json.Number (from JSON), or strings.
+func toInt(v interface{}) (int, error) {
+	switch n := v.(type) {
+	case int:
+		return n, nil
+	case int64:
+		return int(n), nil
+	case float64:
+		if n != float64(int(n)) {
+			return 0, fmt.Errorf("not an integer: %v", n)
+		}
+		return int(n), nil
+	case json.Number:
+		i, err := n.Int64()
+		return int(i), err
+	case string:
+		return strconv.Atoi(n)
+	default:
+		return 0, fmt.Errorf("not an integer: %v", v)
+	}
+}
+
+// checkTemplateFuncs returns an error if any of the allowed functions do not
+// exist, which is likely a typo. pluginFuncs are the functions loaded from
+// [PluginConfig.FuncPlugins].
+func checkTemplateFuncs(allowed []string, pluginFuncs template.FuncMap) *types.Error {
+	funcs := funcMap(nil, funcOptions{pluginFuncs: pluginFuncs})
+	for _, name := range allowed {
+		if _, ok := funcs[name]; !ok {
+			return types.NewError(
+				types.ErrInvalidNetworkConfig,
+				fmt.Sprintf("unknown function in templateFuncs: %s", name),
+				"",
+			)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/containernetworking/cni/pkg/types"
+	"github.com/google/uuid"
+)
+
+func TestToInt(t *testing.T) {
+	valid := []interface{}{5, int64(5), float64(5), json.Number("5"), "5"}
+	for _, v := range valid {
+		if got, err := toInt(v); err != nil || got != 5 {
+			t.Errorf("toInt(%#v) = %d, %v", v, got, err)
+		}
+	}
+
+	invalid := []interface{}{5.5, json.Number("5.5"), "five", nil, true}
+	for _, v := range invalid {
+		if _, err := toInt(v); err == nil {
+			t.Errorf("expected error for toInt(%#v)", v)
+		}
+	}
+}
+
+func TestCallID(t *testing.T) {
+	stdin := []byte(`{"type": "gator", "plugin": "debug", "config": {"c": "{{ callID }}"}, "patch": "{\"a\": \"{{ callID }}\", \"b\": \"{{ callID }}\"}"}`)
+	conf, err := parseConf(stdin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := map[string]string{}
+	if err := json.Unmarshal(conf.downstreamConfig, &out); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := uuid.Parse(out["a"]); err != nil {
+		t.Fatalf("expected a UUID, got %q", out["a"])
+	}
+	if out["a"] != out["b"] || out["a"] != out["c"] {
+		t.Fatalf("expected the same callID everywhere, got %v", out)
+	}
+}
+
+func Example_mtuMinus() {
+	stdin := []byte(`{"type": "gator", "plugin": "debug", "prevResult": {"interfaces": [{"name": "eth0", "mtu": 1500}]}, "patch": "{\"mtu\": {{ with index .prevResult.interfaces 0 }}{{ mtuMinus .mtu 50 }}{{ end }}}"}`)
+	conf, err := parseConf(stdin)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	printKeys(conf.downstreamConfig, "mtu")
+
+	// Output:
+	// mtu: 1450
+}
+
+func TestMTUMinusInvalid(t *testing.T) {
+	if _, err := mtuMinus(1500, 1500); err == nil {
+		t.Error("expected error when the overhead is not less than the base")
+	}
+	if _, err := mtuMinus("big", 50); err == nil {
+		t.Error("expected error for an invalid base")
+	}
+}
+
+func Example_clamp() {
+	stdin := []byte(`{"type": "gator", "plugin": "debug", "mtu": 1300, "patch": "{\"mtu\": {{ clamp (mtuMinus .mtu 50) 1280 9000 }}}"}`)
+	conf, err := parseConf(stdin)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	printKeys(conf.downstreamConfig, "mtu")
+
+	// Output:
+	// mtu: 1280
+}
+
+func TestClamp(t *testing.T) {
+	tests := []struct {
+		value, min, max interface{}
+		want            int
+	}{
+		{1500, 1280, 9000, 1500},
+		{json.Number("9216"), 1280, 9000, 9000},
+		{float64(-1), 0, 10, 0},
+		{"5", "5", "5", 5},
+	}
+	for _, test := range tests {
+		if got, err := clamp(test.value, test.min, test.max); err != nil || got != test.want {
+			t.Errorf("clamp %v %v %v: expected %d, got %d, %v", test.value, test.min, test.max, test.want, got, err)
+		}
+	}
+	if _, err := clamp(1500, 9000, 1280); err == nil {
+		t.Error("expected error when min is greater than max")
+	}
+	if _, err := clamp("big", 0, 10); err == nil {
+		t.Error("expected error for an invalid value")
+	}
+}
+
+func TestDurationSeconds(t *testing.T) {
+	stdin := []byte(`{"type": "gator", "plugin": "debug", "lease": "2m", "patch": "{\"leaseTime\": {{ durationSeconds .lease }}}"}`)
+	conf, err := parseConf(stdin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"lease":"2m","leaseTime":120,"type":"debug"}`; string(conf.downstreamConfig) != want {
+		t.Fatalf("expected %s, got %s", want, conf.downstreamConfig)
+	}
+
+	if got, err := durationSeconds("1500ms"); err != nil || got != 1 {
+		t.Errorf("expected 1500ms to be truncated to 1, got %d, %v", got, err)
+	}
+
+	stdin = []byte(`{"type": "gator", "plugin": "debug", "patch": "{\"leaseTime\": {{ durationSeconds \"soon\" }}}"}`)
+	if _, err := parseConf(stdin); err == nil || err.Code != ErrInvalidPatchTemplate {
+		t.Fatalf("expected code %d, got %v", ErrInvalidPatchTemplate, err)
+	}
+}
+
+func Example_zeroPad() {
+	stdin := []byte(`{"type": "gator", "plugin": "debug", "index": 7, "patch": "{\"ifname\": \"veth{{ zeroPad .index 4 }}\"}"}`)
+	conf, err := parseConf(stdin)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	printKeys(conf.downstreamConfig, "ifname")
+
+	// Output:
+	// ifname: "veth0007"
+}
+
+func TestZeroPadInvalid(t *testing.T) {
+	if _, err := zeroPad("x", 4); err == nil {
+		t.Error("expected error for an invalid number")
+	}
+	if _, err := zeroPad(7, -1); err == nil {
+		t.Error("expected error for a negative width")
+	}
+}
+
+func TestParseConfTemplateFuncs(t *testing.T) {
+	stdin := []byte(`{"type": "gator", "plugin": "debug", "templateFuncs": ["cidrHost", "toJson"], "patch": "{\"gw\": {{ cidrHost \"10.0.0.0/24\" 1 | toJson }}}"}`)
+	if _, err := parseConf(stdin); err != nil {
+		t.Fatalf("expected allowed functions to be available, got %v", err)
+	}
+
+	stdin = []byte(`{"type": "gator", "plugin": "debug", "templateFuncs": ["toJson"], "patch": "{\"host\": \"{{ env \"HOSTNAME\" }}\"}"}`)
+	_, err := parseConf(stdin)
+	if err == nil || err.Code != types.ErrDecodingFailure || !strings.Contains(err.Details, `"env" not defined`) {
+		t.Fatalf("expected a parse error for a function which is not allowed, got %v", err)
+	}
+
+	stdin = []byte(`{"type": "gator", "plugin": "debug", "templateFuncs": ["toJSON"]}`)
+	if _, err := parseConf(stdin); err == nil || err.Code != types.ErrInvalidNetworkConfig {
+		t.Fatalf("expected an error for an unknown function, got %v", err)
+	}
+}
+
+func TestTemplateFuncOptionsNotInData(t *testing.T) {
+	stdin := []byte(`{"type": "gator", "plugin": "debug", "templateFuncs": ["tpl"], "readFileRoots": ["/etc"], "patch": "{\"seen\": \"{{ .TemplateFuncs }}{{ .ReadFileRoots }}{{ .PluginFuncs }}\"}"}`)
+	conf, err := parseConf(stdin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := map[string]interface{}{}
+	json.Unmarshal(conf.downstreamConfig, &out)
+	if out["seen"] != "" {
+		t.Fatalf("expected the function options not to be in the template data, got %v", out["seen"])
+	}
+
+	// Nested templates have the same allow-list
+	stdin = []byte(`{"type": "gator", "plugin": "debug", "templateFuncs": ["tpl"], "nested": "{{ env \"HOME\" }}", "patch": "{\"home\": \"{{ tpl .nested }}\"}"}`)
+	if _, err := parseConf(stdin); err == nil || !strings.Contains(err.Details, `"env" not defined`) {
+		t.Fatalf("expected env not to be allowed in tpl, got %v", err)
+	}
+}
+
+func Example_tpl() {
+	stdin := []byte(`{"type": "gator", "plugin": "debug", "name": "net-{{ .plugin }}", "patch": "{\"ifname\": \"{{ tpl .name }}\"}"}`)
+	conf, err := parseConf(stdin)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	printKeys(conf.downstreamConfig, "ifname")
+
+	// Output:
+	// ifname: "net-debug"
+}
+
+func TestTplRecursion(t *testing.T) {
+	stdin := []byte(`{"type": "gator", "plugin": "debug", "loop": "{{ tpl .loop }}", "patch": "{\"a\": \"{{ tpl .loop }}\"}"}`)
+	_, err := parseConf(stdin)
+	if err == nil || err.Code != ErrInvalidPatchTemplate {
+		t.Fatalf("expected code %d, got %v", ErrInvalidPatchTemplate, err)
+	}
+	if !strings.Contains(err.Details, "nested more than") {
+		t.Fatalf("expected the nesting limit to be reached, got: %s", err.Details)
+	}
+}
+
+func Example_b64decBytes() {
+	stdin := []byte(`{"type": "gator", "plugin": "debug", "args": {"key": "3q2+7w=="}, "patch": "{\"key\": \"{{ .args.key | b64decBytes | hexEncode }}\"}"}`)
+	conf, err := parseConf(stdin)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	printKeys(conf.downstreamConfig, "key")
+
+	stdin = []byte(`{"type": "gator", "plugin": "debug", "patch": "{\"key\": \"{{ b64decBytes \"not base64!\" }}\"}"}`)
+	_, err = parseConf(stdin)
+	fmt.Println(err.Code == ErrInvalidPatchTemplate)
+
+	// Output:
+	// key: "deadbeef"
+	// true
+}
+
+func Example_toStableJSON() {
+	stdin := []byte(`{"type": "gator", "plugin": "debug", "inner": {"b": [1, "<2>"], "a": {"d": 4, "c": 3}}, "patch": "{\"blob\": {{ toStableJSON .inner | toJson }}}"}`)
+	conf, err := parseConf(stdin)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	out := map[string]interface{}{}
+	_ = json.Unmarshal(conf.downstreamConfig, &out)
+	fmt.Println(out["blob"])
+
+	// Output:
+	// {"a":{"c":3,"d":4},"b":[1,"<2>"]}
+}
+
+func Example_mergeObjects() {
+	stdin := []byte(`{"type": "gator", "plugin": "debug", "base": {"type": "host-local", "ranges": [[{"subnet": "10.0.0.0/24"}]], "dataDir": "/var/lib/cni"}, "overrides": {"dataDir": null, "routes": [{"dst": "0.0.0.0/0"}]}, "patch": "{\"ipam\": {{ mergeObjects .base .overrides | toJson }}, \"base\": {{ .base | toJson }}}"}`)
+	conf, err := parseConf(stdin)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	printKeys(conf.downstreamConfig, "ipam", "base")
+
+	// Output:
+	// ipam: {"ranges":[[{"subnet":"10.0.0.0/24"}]],"routes":[{"dst":"0.0.0.0/0"}],"type":"host-local"}
+	// base: {"dataDir":"/var/lib/cni","ranges":[[{"subnet":"10.0.0.0/24"}]],"type":"host-local"}
+}
+
+func TestGatorVersion(t *testing.T) {
+	stdin := []byte(`{"type": "gator", "plugin": "debug", "patch": "{\"labels\": {\"gator\": \"{{ gatorVersion }}\"}}"}`)
+	conf, err := parseConf(stdin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"labels":{"gator":"` + Version + `"},"type":"debug"}`; string(conf.downstreamConfig) != want {
+		t.Fatalf("expected %s, got %s", want, conf.downstreamConfig)
+	}
+}
+
+func TestJSONNull(t *testing.T) {
+	stdin := []byte(`{"type": "gator", "plugin": "debug", "untagged": true, "config": {"vlan": 100, "mtu": 1500}, "patch": "{\"vlan\": {{ if .untagged }}{{ jsonNull }}{{ else }}200{{ end }}}"}`)
+	conf, err := parseConf(stdin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"mtu":1500,"type":"debug","untagged":true}`; string(conf.downstreamConfig) != want {
+		t.Fatalf("expected %s, got %s", want, conf.downstreamConfig)
+	}
+}
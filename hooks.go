@@ -0,0 +1,49 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/containernetworking/cni/pkg/types"
+)
+
+// renderCommand executes each element of command as a template with data.
+func renderCommand(name string, command []string, data interface{}, opts funcOptions) ([]string, *types.Error) {
+	rendered := make([]string, len(command))
+	for i, arg := range command {
+		out, err := executeTemplate(fmt.Sprintf("%s[%d]", name, i), arg, data, opts)
+		if err != nil {
+			return nil, err
+		}
+		rendered[i] = string(out)
+	}
+	return rendered, nil
+}
+
+// runHook runs command with stdin and env. If it fails, the returned error has
+// the given code and includes its output.
+func runHook(ctx context.Context, name string, command []string, stdin []byte, env []string, code uint) *types.Error {
+	output := &bytes.Buffer{}
+	cmd := exec.CommandContext(ctx, command[0], command[1:]...)
+	cmd.WaitDelay = waitDelay
+	cmd.Env = env
+	cmd.Stdin = bytes.NewReader(stdin)
+	cmd.Stdout = output
+	cmd.Stderr = output
+
+	if err := cmd.Run(); err != nil {
+		details := err.Error()
+		if out := strings.TrimSpace(output.String()); out != "" {
+			details = fmt.Sprintf("%s: %s", details, truncate(out, maxLoggedStderr))
+		}
+		return types.NewError(
+			code,
+			fmt.Sprintf("%s command failed: %s", name, command[0]),
+			details,
+		)
+	}
+	return nil
+}
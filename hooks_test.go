@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRunPreExec(t *testing.T) {
+	dir := t.TempDir()
+	called := filepath.Join(dir, "called")
+	writeFakePlugin(t, dir, "recorder", `touch `+called)
+	t.Setenv("CNI_PATH", dir)
+
+	t.Run("failure aborts delegation", func(t *testing.T) {
+		stdin := `{"type": "gator", "plugin": "recorder", "preExec": ["sh", "-c", "echo no sysctl for {{ .plugin }}; exit 1"]}`
+		stdout := &bytes.Buffer{}
+		if code := run(nil, bytes.NewBufferString(stdin), stdout, &bytes.Buffer{}); code != ErrPreExecFailed {
+			t.Fatalf("expected exit code %d, got %d", ErrPreExecFailed, code)
+		}
+		if _, err := os.Stat(called); err == nil {
+			t.Fatal("expected the downstream plugin not to be called")
+		}
+		if !strings.Contains(stdout.String(), "no sysctl for recorder") {
+			t.Fatalf("expected the templated command output in the error, got %q", stdout)
+		}
+	})
+
+	t.Run("success continues delegation", func(t *testing.T) {
+		stdin := `{"type": "gator", "plugin": "recorder", "preExec": ["sh", "-c", "test -n \"$CNI_PATH\" && test {{ .plugin }} = recorder"]}`
+		if code := run(nil, bytes.NewBufferString(stdin), &bytes.Buffer{}, &bytes.Buffer{}); code != 0 {
+			t.Fatalf("expected exit code 0, got %d", code)
+		}
+		if _, err := os.Stat(called); err != nil {
+			t.Fatal("expected the downstream plugin to be called")
+		}
+	})
+}
+
+func TestRunHooksEnv(t *testing.T) {
+	dir := t.TempDir()
+	writeFakePlugin(t, dir, "ok", `echo '{"cniVersion": "1.0.0"}'`)
+	t.Setenv("CNI_PATH", dir)
+	t.Setenv("HOOK_SECRET", "1")
+
+	// Both hooks see the filtered environment and the log level, like the plugin
+	check := `test -z \"$HOOK_SECRET\" && test \"$CNI_LOG_LEVEL\" = debug && test \"$CNI_IFNAME\" = net1`
+	stdin := `{"type": "gator", "plugin": "ok", "envPrefixAllow": ["PATH"], "downstreamLogLevel": "debug", "ifnameOverride": "net1", "preExec": ["sh", "-c", "` + check + `"], "postExec": ["sh", "-c", "` + check + `"], "postExecFailure": "abort"}`
+	stdout := &bytes.Buffer{}
+	if code := run(nil, bytes.NewBufferString(stdin), stdout, &bytes.Buffer{}); code != 0 {
+		t.Fatalf("expected exit code 0, got %d: %s", code, stdout)
+	}
+}
+
+func TestRunPostExec(t *testing.T) {
+	dir := t.TempDir()
+	recorded := filepath.Join(dir, "result.json")
+	writeFakePlugin(t, dir, "ok", `echo '{"cniVersion": "1.0.0"}'`)
+	t.Setenv("CNI_PATH", dir)
+
+	t.Run("receives the result", func(t *testing.T) {
+		stdin := `{"type": "gator", "plugin": "ok", "postExec": ["sh", "-c", "cat > ` + recorded + `"]}`
+		stdout := &bytes.Buffer{}
+		if code := run(nil, bytes.NewBufferString(stdin), stdout, &bytes.Buffer{}); code != 0 {
+			t.Fatalf("expected exit code 0, got %d", code)
+		}
+		got, err := os.ReadFile(recorded)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, stdout.Bytes()) {
+			t.Fatalf("expected postExec to receive %q, got %q", stdout, got)
+		}
+	})
+
+	t.Run("failure warns by default", func(t *testing.T) {
+		logs := captureLogs(t)
+		stdin := `{"type": "gator", "plugin": "ok", "postExec": ["false"]}`
+		stdout := &bytes.Buffer{}
+		if code := run(nil, bytes.NewBufferString(stdin), stdout, &bytes.Buffer{}); code != 0 {
+			t.Fatalf("expected exit code 0, got %d", code)
+		}
+		if !strings.Contains(stdout.String(), "cniVersion") {
+			t.Fatalf("expected the result, got %q", stdout)
+		}
+		if !strings.Contains(logs.String(), "postExec command failed") {
+			t.Fatalf("expected a warning, got %q", logs)
+		}
+	})
+
+	t.Run("failure aborts", func(t *testing.T) {
+		stdin := `{"type": "gator", "plugin": "ok", "postExec": ["false"], "postExecFailure": "abort"}`
+		stdout := &bytes.Buffer{}
+		if code := run(nil, bytes.NewBufferString(stdin), stdout, &bytes.Buffer{}); code != ErrPostExecFailed {
+			t.Fatalf("expected exit code %d, got %d", ErrPostExecFailed, code)
+		}
+		if strings.Contains(stdout.String(), "cniVersion") {
+			t.Fatalf("expected no result, got %q", stdout)
+		}
+	})
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// pluginExists returns whether the plugin name is an executable in CNI_PATH, in
+// the same way as the downstream plugin is found, so that templates can
+// configure a plugin differently depending on whether a companion plugin is
+// installed. The plugin is not executed. Names which are paths are never found.
+func pluginExists(name string) bool {
+	if name == "" || strings.ContainsRune(name, filepath.Separator) {
+		return false
+	}
+	_, err := getPluginPath(name)
+	return err == nil
+}
+
+// defaultResolvConf is read by hostNameservers when no path is given.
+const defaultResolvConf = "/etc/resolv.conf"
+
+// hostNameservers returns the nameservers in the resolv.conf at the optional
+// path, which defaults to /etc/resolv.conf. Any other path must be within the
+// roots, like [fileReader.jsonFile]. If the file cannot be read, it returns an
+// empty list.
+func (r fileReader) hostNameservers(path ...string) ([]string, error) {
+	p, err := r.resolvConf(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return []string{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("hostNameservers: %w", err)
+	}
+	servers, err := readNameservers(p)
+	if err != nil {
+		return []string{}, nil
+	}
+	return servers, nil
+}
+
+// mustHostNameservers is like [fileReader.hostNameservers], but returns an
+// error if the file cannot be read.
+func (r fileReader) mustHostNameservers(path ...string) ([]string, error) {
+	p, err := r.resolvConf(path)
+	if err != nil {
+		return nil, fmt.Errorf("mustHostNameservers: %w", err)
+	}
+	servers, err := readNameservers(p)
+	if err != nil {
+		return nil, fmt.Errorf("mustHostNameservers: %w", err)
+	}
+	return servers, nil
+}
+
+// resolvConf returns the resolv.conf path for hostNameservers, which is
+// [defaultResolvConf] if path is empty. Any other path is resolved by
+// [fileReader.resolve], unless it is the default.
+func (r fileReader) resolvConf(path []string) (string, error) {
+	if len(path) == 0 || path[0] == defaultResolvConf {
+		return defaultResolvConf, nil
+	}
+	return r.resolve(path[0])
+}
+
+// readNameservers returns the nameservers in the resolv.conf at path.
+func readNameservers(path string) ([]string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	servers := []string{}
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "nameserver" {
+			servers = append(servers, fields[1])
+		}
+	}
+	return servers, nil
+}
+
+// fileReader reads files on the host for templates, but only within roots,
+// which are the [PluginConfig.ReadFileRoots].
+type fileReader struct {
+	roots []string
+}
+
+// jsonFile returns the value at the JSON pointer in the JSON file at path,
+// such as jsonFile "/etc/node.json" "/zone". The path must be within one of the
+// roots, after symlinks are resolved.
+func (r fileReader) jsonFile(path, pointer string) (interface{}, error) {
+	resolved, err := r.resolve(path)
+	if err != nil {
+		return nil, fmt.Errorf("jsonFile: %w", err)
+	}
+	b, err := os.ReadFile(resolved)
+	if err != nil {
+		return nil, fmt.Errorf("jsonFile: %w", err)
+	}
+	var doc interface{}
+	if err := json.Unmarshal(b, &doc); err != nil {
+		return nil, fmt.Errorf("jsonFile: %s: %w", path, err)
+	}
+	v, err := lookupPointer(doc, pointer)
+	if err != nil {
+		return nil, fmt.Errorf("jsonFile: %s: %w", path, err)
+	}
+	return v, nil
+}
+
+// resolve returns the absolute path of path with symlinks resolved, or an
+// error if it is not within one of the roots.
+func (r fileReader) resolve(path string) (string, error) {
+	if len(r.roots) == 0 {
+		return "", fmt.Errorf("no readFileRoots are configured")
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	// The cleaned path is checked before the filesystem is touched, so that
+	// whether a file outside the roots exists is not revealed
+	if !r.within(abs) {
+		return "", fmt.Errorf("%s is not within readFileRoots", path)
+	}
+	resolved, err := filepath.EvalSymlinks(abs)
+	if err != nil {
+		return "", err
+	}
+	if !r.within(resolved) {
+		return "", fmt.Errorf("%s is not within readFileRoots", path)
+	}
+	return resolved, nil
+}
+
+// within returns true if the absolute path is within one of the roots, either
+// as they are or with their symlinks resolved.
+func (r fileReader) within(path string) bool {
+	for _, root := range r.roots {
+		abs, err := filepath.Abs(root)
+		if err != nil {
+			continue
+		}
+		resolved, err := filepath.EvalSymlinks(abs)
+		if err != nil {
+			resolved = abs
+		}
+		for _, root := range []string{abs, resolved} {
+			if rel, err := filepath.Rel(root, path); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// lookupPointer returns the value at the RFC6901 JSON pointer in doc.
+func lookupPointer(doc interface{}, pointer string) (interface{}, error) {
+	if pointer == "" {
+		return doc, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("invalid JSON pointer: %s", pointer)
+	}
+	v := doc
+	for _, token := range strings.Split(pointer[1:], "/") {
+		token = strings.NewReplacer("~1", "/", "~0", "~").Replace(token)
+		switch node := v.(type) {
+		case map[string]interface{}:
+			child, ok := node[token]
+			if !ok {
+				return nil, fmt.Errorf("no value at %s", pointer)
+			}
+			v = child
+		case []interface{}:
+			i, err := strconv.Atoi(token)
+			if err != nil || i < 0 || i >= len(node) {
+				return nil, fmt.Errorf("no value at %s", pointer)
+			}
+			v = node[i]
+		default:
+			return nil, fmt.Errorf("no value at %s", pointer)
+		}
+	}
+	return v, nil
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Example_hostNameservers() {
+	stdin := []byte(`{"type": "gator", "plugin": "debug", "readFileRoots": ["testdata"], "patch": "{\"dns\": {\"nameservers\": {{ hostNameservers \"testdata/resolv.conf\" | toJson }}}}"}`)
+	conf, err := parseConf(stdin)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	printKeys(conf.downstreamConfig, "dns")
+
+	// Output:
+	// dns: {"nameservers":["10.96.0.10","fd00:10:96::a"]}
+}
+
+func TestHostNameserversMissing(t *testing.T) {
+	files := fileReader{roots: []string{"testdata"}}
+	servers, err := files.hostNameservers("testdata/missing.conf")
+	if err != nil || len(servers) != 0 {
+		t.Fatalf("expected an empty list, got %v, %v", servers, err)
+	}
+	if _, err := files.mustHostNameservers("testdata/missing.conf"); err == nil {
+		t.Fatal("expected error for a missing file")
+	}
+}
+
+func TestHostNameserversOutsideRoots(t *testing.T) {
+	files := fileReader{roots: []string{"testdata"}}
+	for _, f := range []func(...string) ([]string, error){files.hostNameservers, files.mustHostNameservers} {
+		if _, err := f("main.go"); err == nil {
+			t.Fatal("expected error for a path outside readFileRoots")
+		}
+	}
+	// A missing file outside the roots is not distinguishable from one which exists
+	if _, err := files.hostNameservers("missing.conf"); err == nil || !strings.Contains(err.Error(), "not within readFileRoots") {
+		t.Fatalf("expected a roots error for a missing file outside readFileRoots, got %v", err)
+	}
+	if _, err := (fileReader{}).hostNameservers("testdata/resolv.conf"); err == nil {
+		t.Fatal("expected error for a path without readFileRoots")
+	}
+}
+
+func TestPluginExists(t *testing.T) {
+	dir := t.TempDir()
+	writeFakePlugin(t, dir, "tuning", `exit 1`)
+	t.Setenv("CNI_PATH", dir)
+
+	stdin := []byte(`{"type": "gator", "plugin": "debug", "patch": "{\"tuning\": {{ pluginExists \"tuning\" }}, \"sbr\": {{ pluginExists \"sbr\" }}}"}`)
+	conf, err := parseConf(stdin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"sbr":false,"tuning":true,"type":"debug"}`; string(conf.downstreamConfig) != want {
+		t.Fatalf("expected %s, got %s", want, conf.downstreamConfig)
+	}
+	if pluginExists("../" + filepath.Base(dir) + "/tuning") {
+		t.Fatal("expected a path not to be found")
+	}
+}
+
+func Example_jsonFile() {
+	stdin := []byte(`{"type": "gator", "plugin": "debug", "readFileRoots": ["testdata"], "patch": "{\"zone\": {{ jsonFile \"testdata/node.json\" \"/node/zone\" | toJson }}, \"subnet\": {{ jsonFile \"testdata/node.json\" \"/node/podCIDRs/0\" | toJson }}}"}`)
+	conf, err := parseConf(stdin)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	printKeys(conf.downstreamConfig, "zone", "subnet")
+
+	// Output:
+	// zone: "us-east-1a"
+	// subnet: "10.244.1.0/24"
+}
+
+func TestJSONFileInvalid(t *testing.T) {
+	r := fileReader{roots: []string{"testdata"}}
+	invalid := map[string][2]string{
+		"missing file":    {"testdata/missing.json", "/node"},
+		"missing pointer": {"testdata/node.json", "/node/region"},
+		"invalid pointer": {"testdata/node.json", "node"},
+		"outside roots":   {"go.mod", ""},
+		"escaping roots":  {"testdata/../go.mod", ""},
+		"not JSON":        {"testdata/resolv.conf", ""},
+	}
+	for name, args := range invalid {
+		if _, err := r.jsonFile(args[0], args[1]); err == nil {
+			t.Errorf("%s: expected error for %s %s", name, args[0], args[1])
+		}
+	}
+
+	if _, err := (fileReader{}).jsonFile("testdata/node.json", "/node"); err == nil {
+		t.Error("expected error without readFileRoots")
+	}
+}
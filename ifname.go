@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/containernetworking/cni/pkg/types"
+)
+
+// maxIfnameLen is the longest interface name which Linux allows, which is
+// IFNAMSIZ minus the terminating null byte.
+const maxIfnameLen = 15
+
+// renderIfname executes the [PluginConfig.IfnameOverride] template with data,
+// and checks that the result is a valid interface name.
+func renderIfname(text string, data interface{}, opts funcOptions) (string, *types.Error) {
+	out, err := executeTemplate("conf.IfnameOverride", text, data, opts)
+	if err != nil {
+		return "", err
+	}
+	ifname := string(out)
+	if err := validateIfname(ifname); err != nil {
+		return "", types.NewError(
+			types.ErrInvalidNetworkConfig,
+			"invalid ifnameOverride",
+			err.Error(),
+		)
+	}
+	return ifname, nil
+}
+
+// validateIfname returns an error if name cannot be used as the name of a
+// Linux network interface.
+func validateIfname(name string) error {
+	if name == "" {
+		return fmt.Errorf("interface name must not be empty")
+	}
+	if len(name) > maxIfnameLen {
+		return fmt.Errorf("interface name %q is longer than %d characters", name, maxIfnameLen)
+	}
+	if name == "." || name == ".." {
+		return fmt.Errorf("interface name must not be %q", name)
+	}
+	if strings.ContainsAny(name, "/: \t\n") {
+		return fmt.Errorf("interface name %q must not contain '/', ':' or whitespace", name)
+	}
+	return nil
+}
+
+// sanitizeIfname returns s as a valid interface name, such as one derived from
+// a pod name. Characters other than ASCII letters, digits, '-', '_' and '.'
+// are replaced with '-', and the result is truncated to maxIfnameLen bytes. It
+// fails if s is empty, since any name made up for it would not be derived
+// from s.
+func sanitizeIfname(s string) (string, error) {
+	if s == "" {
+		return "", fmt.Errorf("sanitizeIfname: interface name must not be empty")
+	}
+	name := []byte{}
+	for _, r := range s {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_', r == '.':
+			name = append(name, byte(r))
+		default:
+			name = append(name, '-')
+		}
+		if len(name) == maxIfnameLen {
+			break
+		}
+	}
+	if ifname := string(name); ifname != "." && ifname != ".." {
+		return ifname, nil
+	}
+	return strings.Repeat("-", len(name)), nil
+}
+
+// setEnv returns env with key set to value, replacing any existing values of
+// key. env is in the same format as [os.Environ].
+func setEnv(env []string, key, value string) []string {
+	out := make([]string, 0, len(env)+1)
+	for _, e := range env {
+		if k, _, _ := strings.Cut(e, "="); k != key {
+			out = append(out, e)
+		}
+	}
+	return append(out, key+"="+value)
+}
+
+// filterEnv returns the variables in env whose names start with one of
+// prefixes. env is in the same format as [os.Environ].
+func filterEnv(env, prefixes []string) []string {
+	out := make([]string, 0, len(env))
+	for _, e := range env {
+		k, _, _ := strings.Cut(e, "=")
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(k, prefix) {
+				out = append(out, e)
+				break
+			}
+		}
+	}
+	return out
+}
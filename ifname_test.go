@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRunIfnameOverride(t *testing.T) {
+	dir := t.TempDir()
+	received := filepath.Join(dir, "ifname")
+	writeFakePlugin(t, dir, "recorder", `printf %s "$CNI_IFNAME" > `+received)
+	t.Setenv("CNI_PATH", dir)
+	t.Setenv("CNI_IFNAME", "eth0")
+
+	stdin := `{"type": "gator", "plugin": "recorder", "ifnameOverride": "{{ .plugin | trunc 3 }}1"}`
+	if code := run(nil, bytes.NewBufferString(stdin), &bytes.Buffer{}, &bytes.Buffer{}); code != 0 {
+		t.Fatalf("expected exit code 0, got %d", code)
+	}
+
+	got, err := os.ReadFile(received)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "rec1" {
+		t.Fatalf("expected the plugin to receive CNI_IFNAME rec1, got %q", got)
+	}
+	if ifname := os.Getenv("CNI_IFNAME"); ifname != "eth0" {
+		t.Fatalf("expected gator's CNI_IFNAME to be unchanged, got %q", ifname)
+	}
+}
+
+func TestValidateIfname(t *testing.T) {
+	for _, name := range []string{"eth0", "net1", "veth-a.b_c"} {
+		if err := validateIfname(name); err != nil {
+			t.Errorf("expected %q to be valid: %v", name, err)
+		}
+	}
+	for _, name := range []string{"", ".", "..", "a/b", "eth0:1", "has space", "averyveryverylongname"} {
+		if err := validateIfname(name); err == nil {
+			t.Errorf("expected %q to be invalid", name)
+		}
+	}
+}
+
+func TestRunDownstreamLogLevel(t *testing.T) {
+	dir := t.TempDir()
+	writeFakePlugin(t, dir, "env", `echo "$CNI_LOG_LEVEL,$PLUGIN_VERBOSITY"`)
+	t.Setenv("CNI_PATH", dir)
+	t.Setenv("CNI_LOG_LEVEL", "info")
+
+	tests := map[string]string{
+		`{"type": "gator", "plugin": "env", "downstreamLogLevel": "debug"}`:                                          "debug,\n",
+		`{"type": "gator", "plugin": "env", "downstreamLogLevel": "5", "downstreamLogLevelEnv": "PLUGIN_VERBOSITY"}`: "info,5\n",
+	}
+	for stdin, want := range tests {
+		stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+		if code := run(nil, bytes.NewBufferString(stdin), stdout, stderr); code != 0 {
+			t.Fatalf("expected exit code 0, got %d: %s", code, stderr)
+		}
+		if stdout.String() != want {
+			t.Errorf("expected the plugin to see %q, got %q", want, stdout)
+		}
+	}
+}
+
+func TestRunEnvPrefixAllow(t *testing.T) {
+	dir := t.TempDir()
+	writeFakePlugin(t, dir, "env", `echo "$CNI_COMMAND,$MYPLUGIN_MODE,$OTHER_MODE"`)
+	t.Setenv("CNI_PATH", dir)
+	t.Setenv("CNI_COMMAND", "ADD")
+	t.Setenv("MYPLUGIN_MODE", "fast")
+	t.Setenv("OTHER_MODE", "slow")
+
+	stdin := `{"type": "gator", "plugin": "env", "envPrefixAllow": ["MYPLUGIN_"]}`
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	if code := run(nil, bytes.NewBufferString(stdin), stdout, stderr); code != 0 {
+		t.Fatalf("expected exit code 0, got %d: %s", code, stderr)
+	}
+	if want := "ADD,fast,\n"; stdout.String() != want {
+		t.Fatalf("expected the plugin to see %q, got %q", want, stdout)
+	}
+}
+
+func TestSanitizeIfname(t *testing.T) {
+	tests := map[string]string{
+		"nginx-deployment-7c5ddbdf54-x8k2q": "nginx-deploymen",
+		"eth0":                              "eth0",
+		"pod/ns:name 1":                     "pod-ns-name-1",
+		"café":                              "caf-",
+		"..":                                "--",
+	}
+	for s, want := range tests {
+		got, err := sanitizeIfname(s)
+		if err != nil || got != want {
+			t.Errorf("sanitizeIfname %q = %q, %v; want %q", s, got, err, want)
+		}
+		if err := validateIfname(got); err != nil {
+			t.Errorf("sanitizeIfname %q is not a valid ifname: %v", s, err)
+		}
+	}
+	if _, err := sanitizeIfname(""); err == nil {
+		t.Error("expected error for an empty name")
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// maxLoggedStderr is the number of bytes of a downstream plugin's stderr that
+// will be included in a log entry.
+const maxLoggedStderr = 1024
+
+// logger is where gator writes its own structured logs. It must never write to
+// stdout, which is reserved for the CNI result.
+var logger = slog.New(slog.NewJSONHandler(os.Stderr, nil))
+
+// logDelegateFailure emits a structured log entry describing a downstream
+// plugin which exited with a non-zero code.
+func logDelegateFailure(pluginPath string, env []string, exitcode int, duration time.Duration, stderr []byte) {
+	logger.Error("downstream plugin failed",
+		"plugin", filepath.Base(pluginPath),
+		"command", lookupEnv(env, "CNI_COMMAND"),
+		"exitcode", exitcode,
+		"duration", duration,
+		"stderr", truncate(string(stderr), maxLoggedStderr),
+	)
+}
+
+// logExec emits a log entry with the plugin which is about to be executed and
+// its environment, for --trace-exec. The values of environment variables named
+// in redactKeys are masked, and every entry is passed through redact, so that
+// secrets from stdin which are also in another variable, such as CNI_ARGS, are
+// masked too.
+func logExec(pluginPath string, env []string, redactKeys []string, redact func(string) string) {
+	traced := make([]string, len(env))
+	for i, e := range env {
+		if k, _, ok := strings.Cut(e, "="); ok && slices.Contains(redactKeys, k) {
+			e = k + "=" + redacted
+		}
+		traced[i] = redact(e)
+	}
+	logger.Info("executing downstream plugin", "path", pluginPath, "env", traced)
+}
+
+// lookupEnv returns the value of key from env, which is in the same format as
+// [os.Environ]. If the key is present more than once, the last value wins.
+func lookupEnv(env []string, key string) string {
+	value := ""
+	for _, e := range env {
+		if k, v, ok := strings.Cut(e, "="); ok && k == key {
+			value = v
+		}
+	}
+	return value
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n] + "...(truncated)"
+}
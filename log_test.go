@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/containernetworking/cni/pkg/types"
+)
+
+// captureLogs redirects [logger] to the returned buffer for the duration of
+// the test.
+func captureLogs(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	buf := &bytes.Buffer{}
+	orig := logger
+	logger = slog.New(slog.NewJSONHandler(buf, nil))
+	t.Cleanup(func() { logger = orig })
+	return buf
+}
+
+func TestDelegateLogsFailure(t *testing.T) {
+	buf := captureLogs(t)
+
+	plugin := writeFakePlugin(t, t.TempDir(), "failing", `echo partial; echo "it broke" >&2; exit 3`)
+	stdout, _, exitcode := delegate(context.Background(), plugin, []byte("{}"), []string{"CNI_COMMAND=ADD"}, nil)
+	if exitcode != 3 {
+		t.Fatalf("expected exit code 3, got %d", exitcode)
+	}
+	if strings.Contains(buf.String(), "partial") {
+		t.Fatalf("log contains downstream stdout: %s", buf.String())
+	}
+	if string(stdout) != "partial\n" {
+		t.Fatalf("unexpected stdout: %q", stdout)
+	}
+
+	entry := map[string]interface{}{}
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("failed to parse log entry %q: %v", buf.String(), err)
+	}
+	expected := map[string]interface{}{
+		"plugin":   "failing",
+		"command":  "ADD",
+		"exitcode": float64(3),
+		"stderr":   "it broke\n",
+	}
+	for k, v := range expected {
+		if entry[k] != v {
+			t.Errorf("expected %s to be %v, got %v", k, v, entry[k])
+		}
+	}
+	if _, ok := entry["duration"]; !ok {
+		t.Error("expected log entry to include duration")
+	}
+}
+
+func TestDelegateNoLogOnSuccess(t *testing.T) {
+	buf := captureLogs(t)
+
+	plugin := writeFakePlugin(t, t.TempDir(), "ok", `echo "{}"`)
+	if _, _, exitcode := delegate(context.Background(), plugin, []byte("{}"), nil, nil); exitcode != 0 {
+		t.Fatalf("expected exit code 0, got %d", exitcode)
+	}
+	if buf.Len() != 0 {
+		t.Fatalf("expected no log output, got %s", buf.String())
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	if got := truncate("abcdef", 3); got != "abc...(truncated)" {
+		t.Fatalf("unexpected truncation: %q", got)
+	}
+	if got := truncate("abc", 3); got != "abc" {
+		t.Fatalf("unexpected truncation: %q", got)
+	}
+}
+
+func TestDelegateExecFailed(t *testing.T) {
+	captureLogs(t)
+	_, stderr, exitcode := delegate(context.Background(), t.TempDir()+"/missing", []byte("{}"), nil, nil)
+	if exitcode != ErrExecFailed {
+		t.Fatalf("expected exit code %d, got %d", ErrExecFailed, exitcode)
+	}
+	if len(stderr) == 0 {
+		t.Fatal("expected the exec error in stderr")
+	}
+}
+
+func TestMain(m *testing.M) {
+	// Keep the output of tests which delegate to failing plugins readable
+	logger = slog.New(slog.NewJSONHandler(io.Discard, nil))
+	os.Exit(m.Run())
+}
+
+func TestRunSlowThreshold(t *testing.T) {
+	dir := t.TempDir()
+	writeFakePlugin(t, dir, "slow", `sleep 0.2; echo '{}'`)
+	t.Setenv("CNI_PATH", dir)
+
+	logs := captureLogs(t)
+	stdin := `{"type": "gator", "plugin": "slow", "slowThreshold": "50ms"}`
+	stdout := &bytes.Buffer{}
+	if code := run(nil, bytes.NewBufferString(stdin), stdout, &bytes.Buffer{}); code != 0 {
+		t.Fatalf("expected exit code 0, got %d", code)
+	}
+	if stdout.String() != "{}\n" {
+		t.Fatalf("expected the plugin to finish, got %q", stdout)
+	}
+
+	entry := map[string]interface{}{}
+	if err := json.Unmarshal(logs.Bytes(), &entry); err != nil {
+		t.Fatalf("failed to parse log entry %q: %v", logs, err)
+	}
+	if entry["msg"] != "downstream plugin was slow" || entry["plugin"] != "slow" {
+		t.Fatalf("unexpected log entry: %v", entry)
+	}
+	if elapsed, _ := entry["elapsed"].(float64); elapsed < float64(50*time.Millisecond) {
+		t.Fatalf("expected elapsed time over the threshold, got %v", entry["elapsed"])
+	}
+
+	logs.Reset()
+	stdin = `{"type": "gator", "plugin": "slow", "slowThreshold": "10s"}`
+	if code := run(nil, bytes.NewBufferString(stdin), &bytes.Buffer{}, &bytes.Buffer{}); code != 0 {
+		t.Fatalf("expected exit code 0, got %d", code)
+	}
+	if logs.Len() != 0 {
+		t.Fatalf("expected no warning under the threshold, got %s", logs)
+	}
+}
+
+func TestRunInvalidSlowThresholdBeforePreExec(t *testing.T) {
+	dir := t.TempDir()
+	ran := filepath.Join(dir, "ran")
+	writeFakePlugin(t, dir, "slow", `echo '{}'`)
+	t.Setenv("CNI_PATH", dir)
+
+	stdin := `{"type": "gator", "plugin": "slow", "slowThreshold": "soon", "preExec": ["touch", "` + ran + `"]}`
+	if code := run(nil, bytes.NewBufferString(stdin), &bytes.Buffer{}, &bytes.Buffer{}); code != int(types.ErrInvalidNetworkConfig) {
+		t.Fatalf("expected exit code %d, got %d", types.ErrInvalidNetworkConfig, code)
+	}
+	if _, err := os.Stat(ran); err == nil {
+		t.Fatal("expected preExec not to run with an invalid slowThreshold")
+	}
+}
+
+func TestRunTraceExec(t *testing.T) {
+	buf := captureLogs(t)
+	dir := t.TempDir()
+	plugin := writeFakePlugin(t, dir, "ok", `echo '{}'`)
+	t.Setenv("CNI_PATH", dir)
+	t.Setenv("CNI_COMMAND", "ADD")
+	t.Setenv("API_TOKEN", "hunter2")
+
+	stdin := `{"type": "gator", "plugin": "ok", "redactKeys": ["API_TOKEN"]}`
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	if code := run([]string{"--trace-exec"}, bytes.NewBufferString(stdin), stdout, stderr); code != 0 {
+		t.Fatalf("expected exit code 0, got %d: %s", code, stderr)
+	}
+
+	entry := struct {
+		Path string   `json:"path"`
+		Env  []string `json:"env"`
+	}{}
+	for _, line := range strings.Split(buf.String(), "\n") {
+		if strings.Contains(line, "executing downstream plugin") {
+			if err := json.Unmarshal([]byte(line), &entry); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if entry.Path != plugin {
+		t.Fatalf("expected the trace to include %s, got %s", plugin, buf)
+	}
+	if !slices.Contains(entry.Env, "CNI_COMMAND=ADD") {
+		t.Fatalf("expected the trace to include CNI_COMMAND, got %v", entry.Env)
+	}
+	if !slices.Contains(entry.Env, "API_TOKEN=***") || strings.Contains(buf.String(), "hunter2") {
+		t.Fatalf("expected API_TOKEN to be redacted, got %v", entry.Env)
+	}
+}
+
+func TestRunTraceExecRedactsValues(t *testing.T) {
+	buf := captureLogs(t)
+	dir := t.TempDir()
+	writeFakePlugin(t, dir, "ok", `echo '{}'`)
+	t.Setenv("CNI_PATH", dir)
+	t.Setenv("CNI_COMMAND", "ADD")
+	t.Setenv("CNI_ARGS", "X=s3cret")
+
+	stdin := `{"type": "gator", "plugin": "ok", "redactKeys": ["token"], "token": "s3cret"}`
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	if code := run([]string{"--trace-exec"}, bytes.NewBufferString(stdin), stdout, stderr); code != 0 {
+		t.Fatalf("expected exit code 0, got %d: %s", code, stderr)
+	}
+	if strings.Contains(buf.String(), "s3cret") {
+		t.Fatalf("trace contains the redacted value: %s", buf)
+	}
+	if !strings.Contains(buf.String(), `"CNI_ARGS=X=***"`) {
+		t.Fatalf("expected the value to be masked in CNI_ARGS, got %s", buf)
+	}
+}
+
+func TestRunEchoConfigOnError(t *testing.T) {
+	buf := captureLogs(t)
+	dir := t.TempDir()
+	writeFakePlugin(t, dir, "failing", `exit 3`)
+	t.Setenv("CNI_PATH", dir)
+	t.Setenv("CNI_COMMAND", "ADD")
+
+	stdin := `{"type": "gator", "plugin": "failing", "echoConfigOnError": true, "redactKeys": ["token"], "token": "s3cr3t", "patch": "{\"mtu\": 1450}"}`
+	if code := run(nil, bytes.NewBufferString(stdin), &bytes.Buffer{}, &bytes.Buffer{}); code != 3 {
+		t.Fatalf("expected exit code 3, got %d", code)
+	}
+
+	entry := struct {
+		Config string `json:"config"`
+	}{}
+	for _, line := range strings.Split(buf.String(), "\n") {
+		if strings.Contains(line, "downstream plugin failed with config") {
+			if err := json.Unmarshal([]byte(line), &entry); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if want := `{"mtu":1450,"token":"***","type":"failing"}`; entry.Config != want {
+		t.Fatalf("expected the config %s to be logged, got %s", want, buf)
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"net"
+)
+
+// parseMAC parses s as an IEEE 802 MAC address, in any of the formats which
+// are accepted by [net.ParseMAC].
+func parseMAC(s string) (net.HardwareAddr, error) {
+	mac, err := net.ParseMAC(s)
+	if err != nil {
+		return nil, fmt.Errorf("parseMAC: %w", err)
+	}
+	return mac, nil
+}
+
+// normalizeMAC returns the MAC address s as colon-separated lowercase hex, such
+// as "aa:bb:cc:dd:ee:ff".
+func normalizeMAC(s string) (string, error) {
+	mac, err := net.ParseMAC(s)
+	if err != nil {
+		return "", fmt.Errorf("normalizeMAC: %w", err)
+	}
+	return mac.String(), nil
+}
+
+// localMAC returns a unicast, locally administered MAC address which is derived
+// from seed, so the same seed always results in the same address.
+func localMAC(seed string) (string, error) {
+	if seed == "" {
+		return "", fmt.Errorf("localMAC: seed must not be empty")
+	}
+	sum := sha256.Sum256([]byte(seed))
+	mac := net.HardwareAddr(sum[:6])
+	// Set the locally administered bit, and clear the multicast bit
+	mac[0] = (mac[0] | 0x02) &^ 0x01
+	return mac.String(), nil
+}
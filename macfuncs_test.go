@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"testing"
+)
+
+func TestMACFuncs(t *testing.T) {
+	t.Setenv("CNI_CONTAINERID", "0123456789abcdef")
+	stdin, cniErr := injectPrevResult(
+		[]byte(`{"type": "gator", "plugin": "debug"}`),
+		[]byte(`{"interfaces": [{"name": "eth0", "mac": "AA-BB-CC-00-11-22", "sandbox": "/var/run/netns/test"}]}`),
+	)
+	if cniErr != nil {
+		t.Fatal(cniErr)
+	}
+	stdin, err := injectPatch(stdin, `{"mac": "{{ sandboxMAC | normalizeMAC }}", "local": "{{ env "CNI_CONTAINERID" | localMAC }}"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf, cniErr := parseConf(stdin)
+	if cniErr != nil {
+		t.Fatal(cniErr)
+	}
+	out := struct {
+		MAC   string `json:"mac"`
+		Local string `json:"local"`
+	}{}
+	if err := json.Unmarshal(conf.downstreamConfig, &out); err != nil {
+		t.Fatal(err)
+	}
+
+	if want := "aa:bb:cc:00:11:22"; out.MAC != want {
+		t.Fatalf("expected mac %s, got %s", want, out.MAC)
+	}
+
+	want, _ := localMAC("0123456789abcdef")
+	if out.Local != want {
+		t.Fatalf("expected a stable local MAC %s, got %s", want, out.Local)
+	}
+	mac, err := net.ParseMAC(out.Local)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mac[0]&0x02 == 0 || mac[0]&0x01 != 0 {
+		t.Fatalf("expected a unicast, locally administered MAC, got %s", mac)
+	}
+}
+
+func TestMACFuncsInvalid(t *testing.T) {
+	if _, err := parseMAC("not-a-mac"); err == nil {
+		t.Fatal("expected error for an invalid MAC")
+	}
+	if _, err := normalizeMAC("00:00:00:00:00"); err == nil {
+		t.Fatal("expected error for a short MAC")
+	}
+	if _, err := localMAC(""); err == nil {
+		t.Fatal("expected error for an empty seed")
+	}
+}
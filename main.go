@@ -20,154 +20,1244 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
-	"html/template"
 	"io"
+	"log/slog"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"slices"
+	"strconv"
 	"strings"
+	"syscall"
+	"text/template"
+	"time"
 
-	sprig "github.com/Masterminds/sprig/v3"
 	"github.com/containernetworking/cni/pkg/types"
 	jsonpatch "github.com/evanphx/json-patch"
+	"go.opentelemetry.io/otel/trace"
 )
 
+const Version = "v0.0.2"
+
+// waitDelay is how long commands may keep their output open after they have
+// been killed when a deadline is exceeded, such as when they have forked.
+const waitDelay = time.Second
+
+// defaultMaxStdinBytes is the largest stdin which is read, unless
+// GATOR_MAX_STDIN_BYTES is set.
+const defaultMaxStdinBytes = 4 << 20
+
+// Error codes for failures within gator itself. Codes 100-127 are reserved for
+// gator, so that they do not collide with the standard CNI error codes (which
+// are below 100). When the downstream plugin fails, its exit code is passed
+// through unchanged.
 const (
-	Version                 = "v0.0.2"
 	ErrInvalidPatchTemplate = 100
 	ErrMergeJSONFailed      = 101
+	ErrPluginNotAllowed     = 102
+	ErrSchemaValidation     = 103
+	ErrPluginNotFound       = 104
+	ErrExecFailed           = 105
+	ErrPatchFetchFailed     = 106
+	ErrPreExecFailed        = 107
+	ErrPostExecFailed       = 108
+	ErrDeadlineExceeded     = 109
+	ErrVersionCheckFailed   = 110
+	ErrTimeoutExceeded      = 111
+	ErrPluginSignaled       = 112
 )
 
+// metaKeys are the keys of gator's own configuration, which are removed before
+// the config is passed to the downstream plugin.
+var metaKeys = []string{
+	"plugin",
+	"pluginByCapability",
+	"config",
+	"configFile",
+	"patch",
+	"skip",
+	"allowedPlugins",
+	"suppressPartialResult",
+	"schemaFile",
+	"patchUnderPluginKey",
+	"protectedKeys",
+	"exitCodeMap",
+	"cleanOnSkip",
+	"requireEnv",
+	"requireEnvExempt",
+	"patchURL",
+	"patchURLTimeout",
+	"patchURLHosts",
+	"redactKeys",
+	"prettyDownstream",
+	"canonicalize",
+	"networkName",
+	"preExec",
+	"postExec",
+	"postExecFailure",
+	"pluginIndex",
+	"pluginSelector",
+	"echoConfigOnError",
+	"slowThreshold",
+	"timeout",
+	"timeoutByPlugin",
+	"jsonPatch",
+	"patchFile",
+	"commandPatches",
+	"ifnameOverride",
+	"checkVersion",
+	"templateFuncs",
+	"funcPlugins",
+	"readFileRoots",
+	"downstreamLogLevel",
+	"downstreamLogLevelEnv",
+	"envPrefixAllow",
+	"envMap",
+	"envToConfig",
+	"defaults",
+	"finalPatch",
+	"identity",
+	"runAsUser",
+	"runAsGroup",
+	"resultKeys",
+	"mergeConfigIntoResult",
+	"resultPatch",
+	"commandResultPatches",
+}
+
+// defaultProtectedKeys are used when [PluginConfig.ProtectedKeys] is not set.
+var defaultProtectedKeys = []string{"cniVersion", "name"}
+
 type PluginConfig struct {
-	// Config is the configuration for the downstream CNI plugin.
+	// Config is the configuration for the downstream CNI plugin. If it contains
+	// template actions, it is executed as a template in the same way as Patch
+	// before the patch is applied to it. Since Config is JSON, strings within
+	// template actions should be quoted with backticks.
 	Config *json.RawMessage
 
+	// ConfigFile is a path to a file containing the configuration for the
+	// downstream CNI plugin, which is used in the same way as Config. It cannot
+	// be used with Config.
+	ConfigFile string
+
 	// Patch is a templatable RFC7396 JSON merge patch which will be applied to
 	// Config. Before the patch is applied, a golang text/template based on the
 	// incoming stdin data (as a plain interface) will be executed on it. This
 	// means that you can use any value that is available via stdin as a template
-	// value in the merge patch.
+	// value in the merge patch. See [templateData] for the additional keys which
+	// are available. In JSON, the patch may be either a string or an object,
+	// which saves escaping it. An object is templated in the same way.
 	Patch string
 
+	// CommandPatches are templatable JSON merge patches for specific values of
+	// CNI_COMMAND, such as ADD or DEL. The patch for the current command is
+	// merged on top of Patch, so where both set a key, the command patch
+	// takes precedence.
+	CommandPatches map[string]string
+
+	// ResultKeys are top-level keys of the downstream config which are copied
+	// into the result printed by the downstream plugin when it succeeds, so
+	// that gator's changes are reflected in what is returned to the runtime.
+	// On conflict, the value from the downstream config replaces the value in
+	// the result. Keys which are not in the downstream config are left as they
+	// are in the result. ResultPatch is applied afterwards.
+	ResultKeys []string
+
+	// MergeConfigIntoResult are top-level keys of the downstream config which
+	// are added to the result printed by the downstream plugin when it
+	// succeeds, so that both can be seen together. On conflict, the value in
+	// the result is kept, unless the key is in ResultKeys. prevResult and
+	// RedactKeys are never added. ResultPatch is applied afterwards.
+	MergeConfigIntoResult []string
+
+	// ResultPatch is a templatable JSON merge patch which is applied to the
+	// result printed by the downstream plugin when it succeeds, for any
+	// CNI_COMMAND (including DEL) which prints a result. It is executed with
+	// the same data as Patch, plus the result as .Result.
+	ResultPatch string
+
+	// CommandResultPatches are like CommandPatches, but for ResultPatch: the
+	// entry for the current CNI_COMMAND is merged on top of ResultPatch.
+	CommandResultPatches map[string]string
+
+	// JSONPatch is a templatable RFC6902 JSON patch: an array of operations
+	// which is applied after the downstream config has been merged with stdin,
+	// so paths are relative to the config the downstream plugin receives. Since
+	// it is templated before it is decoded, the operations can be generated
+	// dynamically, such as with a range over the prevResult.
+	JSONPatch string
+
+	// EnvMap maps JSON pointers into the downstream config, such as "/mtu", to
+	// the names of environment variables. After the patches have been applied,
+	// each field is set to the value of its environment variable as a string.
+	// Fields whose environment variable is not set are left unchanged.
+	EnvMap map[string]string
+
+	// EnvToConfig is like EnvMap, but is a list which is applied in order
+	// after EnvMap, and each field may have a default. A field whose
+	// environment variable is not set is set to its default, or left unchanged
+	// if the default is empty.
+	EnvToConfig []EnvField
+
+	// FinalPatch is a templatable RFC7396 JSON merge patch which is applied to
+	// the downstream config last, after it has been merged with stdin and the
+	// other patches have been applied. In addition to the data for Patch, its
+	// template can reference the merged config as .Merged.
+	FinalPatch string
+
+	// Defaults is an object which the downstream config is merged on top of, so
+	// its values are only used for keys (at any depth) which are not already
+	// set after Config, stdin and the patch have been merged.
+	Defaults json.RawMessage
+
+	// PatchFile is a path to a file containing the Patch template. If the file
+	// is gzip-compressed, it is decompressed first. It cannot be used with Patch
+	// or PatchURL.
+	PatchFile string
+
+	// PatchURL is an HTTP(S) URL which the Patch template is fetched from on
+	// every invocation. It cannot be used with Patch.
+	PatchURL string
+
+	// PatchURLTimeout is the timeout for fetching PatchURL, as a Go duration.
+	// Defaults to 5s.
+	PatchURLTimeout string
+
+	// PatchURLHosts is an optional list of hosts which PatchURL may be fetched
+	// from. If it is not empty, any other host is rejected, including when it
+	// is the target of a redirect.
+	PatchURLHosts []string
+
+	// PatchUnderPluginKey causes the rendered patch to be merged under a key
+	// with the same name as Plugin, for plugins which are configured with a
+	// nested object named after themselves. The rendered patch must be a JSON
+	// object.
+	PatchUnderPluginKey bool
+
+	// NetworkName is a template for the top-level name of the downstream config.
+	// If it is set, it overrides the name from stdin, even though name is
+	// protected by default. It must not render to an empty string.
+	NetworkName string
+
 	// Plugin is the name of the downstream CNI plugin which will be called.
 	Plugin string
 
+	// PluginByCapability is an experimental alternative to Plugin, which
+	// delegates to the first plugin in CNI_PATH whose VERSION output lists the
+	// capability in a "capabilities" array. Every plugin may be called to find
+	// it, so it must be enabled with GATOR_EXPERIMENTAL=pluginByCapability, and
+	// the result is cached in GATOR_PATH_CACHE if it is set.
+	PluginByCapability string
+
+	// AllowedPlugins is an optional list of downstream plugin names that gator
+	// is permitted to delegate to. If it is not empty, any other plugin will be
+	// rejected before it is executed.
+	AllowedPlugins []string
+
+	// ExitCodeMap remaps the exit code of the downstream plugin to the exit code
+	// that gator exits with. Codes which are not in the map are unchanged.
+	ExitCodeMap map[int]int
+
+	// SchemaFile is an optional path to a JSON schema. If it is set, the
+	// generated downstream config is validated against it before delegating.
+	SchemaFile string
+
+	// PreExec is a command (and its arguments) which is run before delegating,
+	// with the same environment as the downstream plugin. Each element is a
+	// template. If the command fails, the downstream plugin is not called.
+	PreExec []string
+
+	// PostExec is a command (and its arguments) which is run after the
+	// downstream plugin succeeds, with the same environment as the downstream
+	// plugin and its result on stdin. Each element is a template.
+	PostExec []string
+
+	// PostExecFailure is what happens when PostExec fails: "warn" (the default)
+	// logs a warning and returns the result anyway, while "abort" returns an
+	// error instead of the result.
+	PostExecFailure string
+
+	// PluginIndex is the index of an entry in the plugins array of stdin, for
+	// when gator is given a whole conflist. If it is set, the downstream config
+	// is merged into that entry rather than the root of stdin.
+	PluginIndex *int
+
+	// PluginSelector is like PluginIndex, but selects the entry in the plugins
+	// array whose keys have the given values (for example, {"type": "bridge"}).
+	// It must match exactly one entry.
+	PluginSelector map[string]interface{}
+
+	// ProtectedKeys are top-level keys which are restored from stdin after all
+	// patches have been merged, so that a patch cannot change them. Defaults to
+	// cniVersion and name. Set it to an empty list to allow all keys to be
+	// patched.
+	ProtectedKeys []string
+
+	// RedactKeys are keys (at any depth) in stdin whose values are secret. Their
+	// values are masked in error messages and logs. The downstream config is not
+	// redacted.
+	RedactKeys []string
+
 	// Skip is an array of CNI_COMMAND values for which no action will be taken.
+	// Each entry is a template, which may render to an empty string to be
+	// ignored. A template which fails is fatal.
 	Skip []string
 
+	// RequireEnv are environment variables, such as CNI_NETNS, which must not
+	// be empty, so that templates which reference them cannot silently
+	// generate the wrong config. They are checked before templating.
+	RequireEnv []string
+
+	// RequireEnvExempt are the CNI_COMMAND values for which RequireEnv is not
+	// checked, because the variables may legitimately be absent. Defaults to
+	// DEL, GC and STATUS.
+	RequireEnvExempt []string
+
+	// CleanOnSkip causes gator's configuration to be removed (and the type set
+	// to Plugin) in what is printed when the command is skipped, so that the
+	// output is a valid config for the downstream plugin. No templates are
+	// executed.
+	CleanOnSkip bool
+
+	// Identity makes gator a pure passthrough, which can also be enabled by
+	// setting GATOR_IDENTITY to true. The downstream plugin is called with
+	// stdin, after gator's configuration has been removed, and its result is
+	// printed as is: no templates are executed and no patches are applied.
+	// AllowedPlugins is still enforced.
+	Identity bool
+
+	// PrettyDownstream causes the config sent to the downstream plugin to be
+	// indented, which is easier to read for plugins that log their config.
+	PrettyDownstream bool
+
+	// Canonicalize causes the downstream config to be serialized with the RFC
+	// 8785 JSON Canonicalization Scheme before it is delegated or printed, so
+	// it can be signed or hashed consistently. This sorts keys and normalizes
+	// numbers (for example, 4.50 becomes 4.5 and 1E30 becomes 1e+30). It
+	// cannot be used with PrettyDownstream.
+	Canonicalize bool
+
+	// EchoConfigOnError causes the downstream config to be logged when the
+	// downstream plugin fails, to diagnose the failure without reproducing it
+	// with --dry-run. Values of RedactKeys are masked.
+	EchoConfigOnError bool
+
+	// SlowThreshold is a Go duration. If the downstream plugin takes longer than
+	// this, a warning is logged with the elapsed time. The plugin is not
+	// stopped.
+	SlowThreshold string
+
+	// Timeout is a Go duration. If the downstream plugin takes longer than this,
+	// it is killed and gator fails. It overrides TimeoutByPlugin.
+	Timeout string
+
+	// TimeoutByPlugin maps plugin names to a default Timeout for that plugin,
+	// so that slow plugins such as dhcp can be given longer than fast ones.
+	TimeoutByPlugin map[string]string
+
+	// SuppressPartialResult controls what happens to the stdout of a downstream
+	// plugin which exits with a non-zero code. If it is true (the default), any
+	// stdout which is not a CNI error is logged as a warning and replaced with
+	// a CNI error, so the runtime is never handed a partial result.
+	SuppressPartialResult *bool
+
+	// RunAsUser and RunAsGroup are the uid and gid which the downstream plugin
+	// is run as, such as to run it as a less privileged user. If only one of
+	// them is set, the other is gator's own. gator must be allowed to change
+	// them, such as by running as root.
+	RunAsUser  *int
+	RunAsGroup *int
+
+	// DownstreamLogLevel is a log level, such as "debug", which the downstream
+	// plugin is called with in the DownstreamLogLevelEnv environment variable.
+	DownstreamLogLevel string
+
+	// DownstreamLogLevelEnv is the name of the environment variable which
+	// DownstreamLogLevel is set in. Defaults to CNI_LOG_LEVEL.
+	DownstreamLogLevelEnv string
+
+	// EnvPrefixAllow are prefixes of the environment variables, such as
+	// "MYPLUGIN_", which are passed to the downstream plugin. If it is not
+	// empty, every other variable is removed, except for the CNI_* variables
+	// which the plugin requires.
+	EnvPrefixAllow []string
+
+	// CheckVersion calls the downstream plugin with the VERSION command before
+	// generating its config. Its supportedVersions are available to templates
+	// as .DownstreamVersions, and gator fails if they do not include the
+	// cniVersion of the downstream config.
+	CheckVersion bool
+
+	// TemplateFuncs is an allow-list of the template functions, from sprig and
+	// gator, which templates may use. Templates which call any other function
+	// fail to parse. If it is empty, every function is available.
+	TemplateFuncs []string
+
+	// FuncPlugins are experimental paths of Go plugins (.so files), or
+	// directories of them, which provide additional template functions with a
+	// [FuncMapProvider]. It must be enabled with GATOR_EXPERIMENTAL=funcPlugins.
+	FuncPlugins []string
+
+	// ReadFileRoots are the directories which templates may read files from,
+	// such as with jsonFile. If it is empty, no files may be read.
+	ReadFileRoots []string
+
+	// IfnameOverride is a templatable interface name which the downstream
+	// plugin and the hooks are called with as CNI_IFNAME, instead of the one
+	// gator was called with. It does not change the CNI_IFNAME of gator itself.
+	IfnameOverride string
+
 	// stdin is the original stdin that gator received
 	stdin []byte
 
+	// data is stdin decoded as a plain interface. See [templateData].
+	data map[string]interface{}
+
 	// downstreamConfig is what will be sent as stdin to the delegated plugin.
+	// If the command is skipped, it is what gator prints instead.
 	downstreamConfig []byte
+
+	// skip is true if the CNI_COMMAND is in Skip.
+	skip bool
+
+	// identity is true if Identity or GATOR_IDENTITY is set.
+	identity bool
+
+	// preExec is PreExec after it has been templated.
+	preExec []string
+
+	// postExec is PostExec after it has been templated.
+	postExec []string
+
+	// ifname is IfnameOverride after it has been templated.
+	ifname string
+
+	// pluginFuncs are the template functions loaded from FuncPlugins.
+	pluginFuncs template.FuncMap
+
+	// downstreamVersions are the versions which the downstream plugin
+	// supports, if CheckVersion is set.
+	downstreamVersions []string
+
+	// warnings are the non-fatal issues collected by [PluginConfig.warn].
+	warnings []string
+
+	// slowThreshold is SlowThreshold after it has been parsed by
+	// [PluginConfig.Validate].
+	slowThreshold time.Duration
+
+	// patchURLTimeout is PatchURLTimeout after it has been parsed by
+	// [PluginConfig.Validate].
+	patchURLTimeout time.Duration
+
+	// parsedTimeout and parsedTimeoutByPlugin are Timeout and TimeoutByPlugin
+	// after they have been parsed by [PluginConfig.Validate]. See
+	// [PluginConfig.timeout].
+	parsedTimeout         time.Duration
+	parsedTimeoutByPlugin map[string]time.Duration
+}
+
+// UnmarshalJSON unmarshals a PluginConfig, where the patch may be either a
+// string or an object.
+func (conf *PluginConfig) UnmarshalJSON(b []byte) error {
+	type plain PluginConfig
+	aux := struct {
+		*plain
+		Patch json.RawMessage `json:"patch"`
+	}{plain: (*plain)(conf)}
+	if err := json.Unmarshal(b, &aux); err != nil {
+		return err
+	}
+
+	patch := bytes.TrimSpace(aux.Patch)
+	switch {
+	case len(patch) == 0 || bytes.Equal(patch, []byte("null")):
+		conf.Patch = ""
+	case patch[0] == '"':
+		return json.Unmarshal(patch, &conf.Patch)
+	case patch[0] == '{':
+		conf.Patch = string(patch)
+	default:
+		return fmt.Errorf("patch must be a string or an object, got %s", patch)
+	}
+	return nil
 }
 
 func main() {
-	if len(os.Args) > 1 && os.Args[1] == "--version" {
-		fmt.Printf("CNI gator plugin %s\n", Version)
-		os.Exit(0)
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// run executes gator with the given command line arguments and standard
+// streams, and returns the exit code.
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("gator", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	version := flags.Bool("version", false, "print the version and exit")
+	dryRun := flags.Bool("dry-run", false, "print the downstream config instead of delegating")
+	showDiff := flags.Bool("diff", false, "print a diff of stdin and the downstream config to stderr instead of delegating")
+	output := flags.String("output", "", "file to write the downstream config to instead of stdout (requires --dry-run)")
+	explain := flags.Bool("explain", false, "print how long each stage took to stderr")
+	check := flags.Bool("check", false, "check that the plugin supports the cniVersion of the downstream config (requires --dry-run)")
+	traceExec := flags.Bool("trace-exec", false, "log the plugin path and environment before delegating")
+	prevResultFile := flags.String("prev-result", "", "file containing a prevResult to inject into stdin (requires --dry-run)")
+	if err := flags.Parse(args); err != nil {
+		return 2
 	}
 
-	stdin, ioerr := io.ReadAll(os.Stdin)
-	if ioerr != nil {
-		err := types.NewError(
-			types.ErrIOFailure,
-			"failed to read stdin",
-			ioerr.Error(),
-		)
-		handleError(err)
-		return
+	if *version {
+		fmt.Fprintf(stdout, "CNI gator plugin %s\n", Version)
+		return 0
+	}
+
+	if flags.NArg() > 0 {
+		return runCommand(flags.Arg(0), flags.Args()[1:], stdin, stdout, stderr)
+	}
+
+	if *prevResultFile != "" && !*dryRun && !*showDiff {
+		fmt.Fprintln(stderr, "--prev-result requires --dry-run or --diff")
+		return 2
 	}
 
-	conf, err := parseConf(stdin)
+	if *output != "" && !*dryRun {
+		fmt.Fprintln(stderr, "--output requires --dry-run")
+		return 2
+	}
+
+	if *check && !*dryRun {
+		fmt.Fprintln(stderr, "--check requires --dry-run")
+		return 2
+	}
+
+	ctx := context.Background()
+	var timings *stageTimings
+	if *explain {
+		timings = &stageTimings{}
+	}
+	shutdown, tracingErr := setupTracing(ctx, timings)
+	if tracingErr != nil {
+		logger.Warn("failed to set up tracing", "error", tracingErr)
+	} else {
+		defer shutdown(ctx)
+		if timings != nil {
+			defer timings.print(stderr)
+		}
+	}
+	ctx, span := tracer.Start(ctx, "gator", trace.WithAttributes(
+		attrCNICommand.String(os.Getenv("CNI_COMMAND")),
+	))
+	defer span.End()
+
+	ctx, cancel, err := withDeadline(ctx)
+	if err != nil {
+		return handleError(stdout, err)
+	}
+	defer cancel()
+
+	input, err := readStdin(stdin)
+	if err != nil {
+		return handleError(stdout, err)
+	}
+
+	if *prevResultFile != "" {
+		prevResult, ioerr := os.ReadFile(*prevResultFile)
+		if ioerr != nil {
+			err := types.NewError(
+				types.ErrIOFailure,
+				"failed to read prevResult file",
+				ioerr.Error(),
+			)
+			return handleError(stdout, err)
+		}
+		if input, err = injectPrevResult(input, prevResult); err != nil {
+			return handleError(stdout, err)
+		}
+	}
+
+	conf, err := parseConfContext(ctx, input)
+	if conf != nil && len(conf.RedactKeys) > 0 {
+		orig := logger
+		logger = slog.New(redactHandler{logger.Handler(), conf.redactor()})
+		defer func() { logger = orig }()
+	}
 	if err != nil {
-		handleError(err)
+		return handleError(stdout, conf.redactError(err))
+	}
+	span.SetAttributes(attrPlugin.String(conf.Plugin))
+	if err := deadlineError(ctx, "generating the downstream config"); err != nil {
+		return handleError(stdout, conf.redactError(err))
+	}
+
+	if conf.skip {
+		fmt.Fprint(stdout, string(conf.downstreamConfig))
+		return 0
+	}
+
+	if *showDiff {
+		cleaned, err := cleanStdin(conf)
+		if err != nil {
+			return handleError(stdout, conf.redactError(err))
+		}
+		// Both sides are redacted, since the diff is printed
+		before, diffErr := conf.redactJSON(cleaned)
+		var after []byte
+		if diffErr == nil {
+			after, diffErr = conf.redactJSON(conf.downstreamConfig)
+		}
+		var diff string
+		if diffErr == nil {
+			diff, diffErr = diffConfigs(before, after)
+		}
+		if diffErr != nil {
+			err := types.NewError(
+				types.ErrDecodingFailure,
+				"failed to diff the downstream config",
+				diffErr.Error(),
+			)
+			return handleError(stdout, conf.redactError(err))
+		}
+		fmt.Fprint(stderr, diff)
 	}
 
-	// For debugging:
-	//fmt.Println(string(conf.downstreamConfig))
+	// With checkVersion, parseConfContext has already checked the version
+	if *check && !conf.CheckVersion {
+		versions, err := queryVersions(ctx, conf)
+		if err == nil {
+			err = checkVersion(versions, conf.downstreamConfig)
+		}
+		if err != nil {
+			return handleError(stdout, conf.redactError(err))
+		}
+	}
+
+	if *dryRun && *output != "" {
+		if ioerr := os.WriteFile(*output, append(conf.downstreamConfig, '\n'), 0o600); ioerr != nil {
+			err := types.NewError(
+				types.ErrIOFailure,
+				"failed to write output file",
+				ioerr.Error(),
+			)
+			return handleError(stdout, err)
+		}
+		return 0
+	}
+
+	if *dryRun {
+		fmt.Fprintln(stdout, string(conf.downstreamConfig))
+		return 0
+	}
+
+	if *showDiff {
+		return 0
+	}
 
 	pluginPath, err := getPluginPath(conf.Plugin)
 	if err != nil {
-		handleError(err)
+		return handleError(stdout, conf.redactError(err))
+	}
+	resultFD, err := openResultFD()
+	if err != nil {
+		return handleError(stdout, conf.redactError(err))
 	}
 
-	stdout, stderr, exitcode := delegate(pluginPath, conf.downstreamConfig, os.Environ())
+	// The hooks run with the same environment as the downstream plugin
+	env := conf.delegateEnv()
+	if len(conf.preExec) > 0 {
+		if err := runHook(ctx, "preExec", conf.preExec, nil, env, ErrPreExecFailed); err != nil {
+			return handleError(stdout, conf.redactError(err))
+		}
+	}
+
+	timeout := conf.timeout()
+	delegateCtx, cancelDelegate := withTimeout(ctx, timeout)
+	defer cancelDelegate()
+
+	_, delegateSpan := tracer.Start(ctx, "delegate")
+	start := time.Now()
+	attr, attrErr := conf.sysProcAttr()
+	if attrErr != nil {
+		err := types.NewError(types.ErrInvalidNetworkConfig, "invalid runAsUser or runAsGroup", attrErr.Error())
+		return handleError(stdout, conf.redactError(err))
+	}
+	if *traceExec {
+		logExec(pluginPath, env, conf.RedactKeys, conf.redactor())
+	}
+	out, errout, exitcode := delegate(delegateCtx, pluginPath, conf.downstreamConfig, env, attr)
+	if elapsed := time.Since(start); conf.slowThreshold > 0 && elapsed > conf.slowThreshold {
+		logger.Warn("downstream plugin was slow",
+			"plugin", conf.Plugin,
+			"command", os.Getenv("CNI_COMMAND"),
+			"elapsed", elapsed,
+			"threshold", conf.slowThreshold,
+		)
+	}
+	delegateSpan.End()
+	if err := deadlineError(ctx, "delegating"); err != nil {
+		return handleError(stdout, conf.redactError(err))
+	}
+	if err := timeoutError(delegateCtx, conf.Plugin, timeout); err != nil {
+		return handleError(stdout, conf.redactError(err))
+	}
+	captureResult(conf.Plugin, os.Getenv("CNI_COMMAND"), out)
+	if exitcode != 0 && conf.EchoConfigOnError {
+		logger.Error("downstream plugin failed with config",
+			"plugin", conf.Plugin,
+			"exitcode", exitcode,
+			"config", string(conf.downstreamConfig),
+		)
+	}
+	if exitcode != 0 && conf.suppressPartialResult() {
+		out = downstreamError(conf.Plugin, out, exitcode)
+	}
+
+	if exitcode == 0 && !conf.identity {
+		if out, err = mergeConfigIntoResult(conf, out); err != nil {
+			return handleError(stdout, conf.redactError(err))
+		}
+		if out, err = copyResultKeys(conf, out); err != nil {
+			return handleError(stdout, conf.redactError(err))
+		}
+		if out, err = patchResult(conf, out); err != nil {
+			return handleError(stdout, conf.redactError(err))
+		}
+		if out, err = addWarnings(conf.warnings, out); err != nil {
+			return handleError(stdout, conf.redactError(err))
+		}
+	}
+
+	if exitcode == 0 && len(conf.postExec) > 0 {
+		if err := runHook(ctx, "postExec", conf.postExec, out, env, ErrPostExecFailed); err != nil {
+			if conf.PostExecFailure == "abort" {
+				fmt.Fprint(stderr, string(errout))
+				return handleError(stdout, conf.redactError(err))
+			}
+			logger.Warn("postExec command failed", "error", err.Error())
+		}
+	}
 
-	fmt.Print(string(stdout))
-	fmt.Fprint(os.Stderr, string(stderr))
-	os.Exit(exitcode)
+	writeResultFD(resultFD, out)
+	fmt.Fprint(stdout, string(out))
+	fmt.Fprint(stderr, string(errout))
+	if mapped, ok := conf.ExitCodeMap[exitcode]; ok {
+		return mapped
+	}
+	return exitcode
 }
 
-func handleError(err *types.Error) {
-	fmt.Fprint(os.Stderr, err.Error())
-	os.Exit(int(err.Code))
+// delegateEnv returns the environment which the downstream plugin and the
+// hooks are called with: gator's own, filtered by EnvPrefixAllow, with the
+// CNI_IFNAME from IfnameOverride and the DownstreamLogLevel.
+func (conf *PluginConfig) delegateEnv() []string {
+	env := os.Environ()
+	if len(conf.EnvPrefixAllow) > 0 {
+		env = filterEnv(env, append([]string{"CNI_"}, conf.EnvPrefixAllow...))
+	}
+	if conf.ifname != "" {
+		env = setEnv(env, "CNI_IFNAME", conf.ifname)
+	}
+	if conf.DownstreamLogLevel != "" {
+		env = setEnv(env, conf.downstreamLogLevelEnv(), conf.DownstreamLogLevel)
+	}
+	return env
 }
 
-// parseConf will return a complete [PluginConfig] based on stdin. If the
-// [PluginConfig.Skip] contains the CNI_COMMAND, it will immediately print what
-// it received on stdin and exit. If an error is encountered, it is returned as
-// a [types.Error].
-func parseConf(stdin []byte) (conf *PluginConfig, err *types.Error) {
-	conf = &PluginConfig{stdin: stdin}
-	if err := json.Unmarshal(stdin, conf); err != nil {
+// parseDuration parses the value of a duration field of [PluginConfig]. An
+// empty value is zero.
+func parseDuration(field, value string) (time.Duration, *types.Error) {
+	if value == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, types.NewError(
+			types.ErrInvalidNetworkConfig,
+			fmt.Sprintf("invalid %s", field),
+			err.Error(),
+		)
+	}
+	return d, nil
+}
+
+// handleError writes err to w as JSON, which is stdout for every failure within
+// gator since runtimes parse errors from stdout, and returns the exit code for
+// it.
+func handleError(w io.Writer, err *types.Error) int {
+	fmt.Fprint(w, errorJSON(err))
+	return int(err.Code)
+}
+
+// errorJSON returns err as a CNI error object, which is how errors are
+// reported to the runtime.
+func errorJSON(err *types.Error) string {
+	b, _ := json.Marshal(err)
+	return string(b)
+}
+
+// readStdin reads all of stdin, up to the limit in GATOR_MAX_STDIN_BYTES (or
+// [defaultMaxStdinBytes]), so that a runaway runtime cannot exhaust memory.
+func readStdin(stdin io.Reader) ([]byte, *types.Error) {
+	limit := int64(defaultMaxStdinBytes)
+	if v := os.Getenv("GATOR_MAX_STDIN_BYTES"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n <= 0 {
+			return nil, types.NewError(
+				types.ErrInvalidEnvironmentVariables,
+				"GATOR_MAX_STDIN_BYTES must be a positive integer",
+				v,
+			)
+		}
+		limit = n
+	}
+
+	input, err := io.ReadAll(io.LimitReader(stdin, limit+1))
+	if err != nil {
+		return nil, types.NewError(
+			types.ErrIOFailure,
+			"failed to read stdin",
+			err.Error(),
+		)
+	}
+	if int64(len(input)) > limit {
+		return nil, types.NewError(
+			types.ErrIOFailure,
+			"stdin is too large",
+			fmt.Sprintf("limit is %d bytes", limit),
+		)
+	}
+	return input, nil
+}
+
+// injectPrevResult sets the prevResult in stdin to prevResult, replacing any
+// that was already there. This allows templates which depend on the result of
+// previous plugins to be tested without a live chain.
+func injectPrevResult(stdin, prevResult []byte) ([]byte, *types.Error) {
+	conf := map[string]interface{}{}
+	if err := json.Unmarshal(stdin, &conf); err != nil {
 		return nil, types.NewError(
 			types.ErrDecodingFailure,
 			"failed to parse JSON config",
 			err.Error(),
 		)
 	}
+	var result interface{}
+	if err := json.Unmarshal(prevResult, &result); err != nil {
+		return nil, types.NewError(
+			types.ErrDecodingFailure,
+			"failed to parse prevResult",
+			err.Error(),
+		)
+	}
+	conf["prevResult"] = result
+	out, err := json.Marshal(conf)
+	if err != nil {
+		return nil, types.NewError(
+			types.ErrDecodingFailure,
+			"failed to inject prevResult",
+			err.Error(),
+		)
+	}
+	return out, nil
+}
+
+// parseConf calls [parseConfContext] with a background context.
+func parseConf(stdin []byte) (conf *PluginConfig, err *types.Error) {
+	return parseConfContext(context.Background(), stdin)
+}
+
+// parseConfContext will return a complete [PluginConfig] based on stdin. If the
+// [PluginConfig.Skip] contains the CNI_COMMAND, the downstream config is not
+// generated and the returned config is marked to be skipped, in which case
+// gator prints what it received on stdin and exits. If an error is
+// encountered, it is returned as a [types.Error].
+func parseConfContext(ctx context.Context, stdin []byte) (conf *PluginConfig, err *types.Error) {
+	_, span := tracer.Start(ctx, "parse")
+	conf, jsonErr := decodeConf(stdin)
+	span.End()
+	if jsonErr != nil {
+		return nil, types.NewError(
+			types.ErrDecodingFailure,
+			"failed to parse JSON config",
+			jsonErr.Error(),
+		)
+	}
+
+	if err := conf.Validate(); err != nil {
+		return conf, err
+	}
+
+	// A skipped command does nothing else, so it is decided before anything
+	// is loaded or executed, including function plugins
+	skip, err := renderSkip(conf)
+	if err != nil {
+		return conf, err
+	}
+	if slices.Contains(skip, os.Getenv("CNI_COMMAND")) {
+		conf.skip = true
+		conf.downstreamConfig = stdin
+		if conf.CleanOnSkip {
+			if conf.downstreamConfig, err = cleanStdin(conf); err != nil {
+				return conf, err
+			}
+		}
+		return conf, nil
+	}
+
+	if conf.pluginFuncs, err = loadFuncPlugins(conf.FuncPlugins); err != nil {
+		return conf, err
+	}
+	if err := checkTemplateFuncs(conf.TemplateFuncs, conf.pluginFuncs); err != nil {
+		return conf, err
+	}
+	if err := conf.resolvePluginByCapability(ctx); err != nil {
+		return conf, err
+	}
+	// The plugin must be allowed before anything can execute it, including
+	// the VERSION command for checkVersion
+	if err := conf.checkAllowedPlugin(); err != nil {
+		return conf, err
+	}
+
+	if conf.identity, err = conf.isIdentity(); err != nil {
+		return conf, err
+	}
+	if conf.identity {
+		conf.downstreamConfig, err = cleanStdin(conf)
+		return conf, err
+	}
+
+	if err := conf.checkRequiredEnv(); err != nil {
+		return conf, err
+	}
+
+	if conf.PatchURL != "" {
+		if conf.Patch, err = fetchPatch(ctx, conf); err != nil {
+			return conf, err
+		}
+	}
+
+	if conf.PatchFile != "" {
+		if conf.Patch, err = readPatchFile(conf.PatchFile); err != nil {
+			return conf, err
+		}
+	}
 
-	if slices.Contains(conf.Skip, os.Getenv("CNI_COMMAND")) {
-		fmt.Print(string(stdin))
-		os.Exit(0)
+	if conf.CheckVersion {
+		if conf.downstreamVersions, err = queryVersions(ctx, conf); err != nil {
+			return conf, err
+		}
 	}
 
-	downstreamConfig, err := generateDownstream(conf)
+	downstreamConfig, err := generateDownstream(ctx, conf)
 	if err != nil {
 		return conf, err
 	}
 
 	conf.downstreamConfig = downstreamConfig
+
+	if conf.CheckVersion {
+		if err := checkVersion(conf.downstreamVersions, downstreamConfig); err != nil {
+			return conf, err
+		}
+	}
+
+	if conf.SchemaFile != "" {
+		if err := validateSchema(conf.SchemaFile, downstreamConfig); err != nil {
+			return conf, err
+		}
+	}
+
+	if conf.PrettyDownstream {
+		indented := &bytes.Buffer{}
+		if err := json.Indent(indented, conf.downstreamConfig, "", "  "); err != nil {
+			return conf, types.NewError(
+				ErrMergeJSONFailed,
+				"failed to indent downstream config",
+				err.Error(),
+			)
+		}
+		conf.downstreamConfig = indented.Bytes()
+	}
+
+	if conf.Canonicalize {
+		if conf.downstreamConfig, err = canonicalize(conf.downstreamConfig); err != nil {
+			return conf, err
+		}
+	}
+
 	return conf, nil
 }
 
-func generateDownstream(conf *PluginConfig) ([]byte, *types.Error) {
-	stdin := conf.stdin
-	tmpl, err := template.New("conf.Patch").Funcs(sprig.FuncMap()).Parse(conf.Patch)
+func generateDownstream(ctx context.Context, conf *PluginConfig) ([]byte, *types.Error) {
+	rawConf := templateData(conf)
+	opts := conf.funcOptions()
+
+	_, span := tracer.Start(ctx, "template")
+	patch, downstreamConf, cniErr := renderTemplates(conf, rawConf)
+	if cniErr == nil {
+		conf.preExec, cniErr = renderCommand("conf.PreExec", conf.PreExec, rawConf, opts)
+	}
+	if cniErr == nil {
+		conf.postExec, cniErr = renderCommand("conf.PostExec", conf.PostExec, rawConf, opts)
+	}
+	if cniErr == nil && conf.IfnameOverride != "" {
+		conf.ifname, cniErr = renderIfname(conf.IfnameOverride, rawConf, opts)
+	}
+	var ops jsonpatch.Patch
+	if cniErr == nil && conf.JSONPatch != "" {
+		ops, cniErr = renderJSONPatch(conf.JSONPatch, rawConf, opts)
+	}
+	span.End()
+	if cniErr != nil {
+		return nil, cniErr
+	}
+
+	_, span = tracer.Start(ctx, "merge")
+	defer span.End()
+
+	cleaned, cniErr := cleanStdin(conf)
+	if cniErr != nil {
+		return nil, cniErr
+	}
+
+	downstream, err := jsonpatch.MergePatch(downstreamConf, patch)
 	if err != nil {
 		return nil, types.NewError(
-			types.ErrDecodingFailure,
-			"failed to parse JSON merge patch template",
+			ErrMergeJSONFailed,
+			"failed to merge patch with downstream config",
 			err.Error(),
 		)
 	}
 
-	type data interface{}
-	var rawConf data
-	err = json.Unmarshal(stdin, &rawConf)
+	finalConfig, cniErr := mergeDownstream(conf, cleaned, downstream)
+	if cniErr != nil {
+		return nil, cniErr
+	}
+
+	if len(conf.Defaults) > 0 {
+		if finalConfig, err = jsonpatch.MergePatch(conf.Defaults, finalConfig); err != nil {
+			return nil, types.NewError(
+				ErrMergeJSONFailed,
+				"failed to merge downstream config with defaults",
+				err.Error(),
+			)
+		}
+	}
+
+	if ops != nil {
+		if finalConfig, cniErr = applyJSONPatch("jsonPatch", ops, finalConfig); cniErr != nil {
+			return nil, cniErr
+		}
+	}
+
+	if len(conf.EnvMap) > 0 {
+		envOps, cniErr := envPatch(conf.EnvMap, conf.warn)
+		if cniErr != nil {
+			return nil, cniErr
+		}
+		if finalConfig, cniErr = applyJSONPatch("envMap", envOps, finalConfig); cniErr != nil {
+			return nil, cniErr
+		}
+	}
+
+	if len(conf.EnvToConfig) > 0 {
+		envOps, cniErr := envToConfigPatch(conf.EnvToConfig, conf.warn)
+		if cniErr != nil {
+			return nil, cniErr
+		}
+		if finalConfig, cniErr = applyJSONPatch("envToConfig", envOps, finalConfig); cniErr != nil {
+			return nil, cniErr
+		}
+	}
+
+	if conf.FinalPatch != "" {
+		if finalConfig, cniErr = applyFinalPatch(conf.FinalPatch, rawConf, opts, finalConfig); cniErr != nil {
+			return nil, cniErr
+		}
+	}
+
+	protected := conf.ProtectedKeys
+	if protected == nil {
+		protected = defaultProtectedKeys
+	}
+	finalConfig, cniErr = restoreKeys(cleaned, finalConfig, protected)
+	if cniErr != nil {
+		return nil, cniErr
+	}
+
+	if conf.NetworkName != "" {
+		name, cniErr := executeTemplate("conf.NetworkName", conf.NetworkName, rawConf, opts)
+		if cniErr != nil {
+			return nil, cniErr
+		}
+		if strings.TrimSpace(string(name)) == "" {
+			return nil, types.NewError(
+				types.ErrInvalidNetworkConfig,
+				"networkName must not be empty",
+				fmt.Sprintf("template: %s", conf.NetworkName),
+			)
+		}
+		return setKey(finalConfig, "name", string(name))
+	}
+
+	return finalConfig, nil
+}
+
+// setKey returns config with the top-level key set to value.
+func setKey(config []byte, key string, value interface{}) ([]byte, *types.Error) {
+	obj := map[string]interface{}{}
+	if err := json.Unmarshal(config, &obj); err != nil {
+		return nil, types.NewError(ErrMergeJSONFailed, fmt.Sprintf("failed to set %s", key), err.Error())
+	}
+	obj[key] = value
+	out, err := json.Marshal(obj)
 	if err != nil {
+		return nil, types.NewError(ErrMergeJSONFailed, fmt.Sprintf("failed to set %s", key), err.Error())
+	}
+	return out, nil
+}
+
+// mergeDownstream merges the downstream config into the cleaned stdin. By
+// default, it is merged into the root. If [PluginConfig.PluginIndex] or
+// [PluginConfig.PluginSelector] is set, it is merged into an entry of the
+// plugins array instead.
+func mergeDownstream(conf *PluginConfig, cleaned, downstream []byte) ([]byte, *types.Error) {
+	if conf.PluginIndex == nil && conf.PluginSelector == nil {
+		finalConfig, err := jsonpatch.MergePatch(cleaned, downstream)
+		if err != nil {
+			return nil, types.NewError(
+				ErrMergeJSONFailed,
+				"failed to merge downstream config with original",
+				err.Error(),
+			)
+		}
+		return finalConfig, nil
+	}
+
+	conflist := map[string]interface{}{}
+	if err := json.Unmarshal(cleaned, &conflist); err != nil {
+		return nil, types.NewError(ErrMergeJSONFailed, "failed to parse conflist", err.Error())
+	}
+	plugins, ok := conflist["plugins"].([]interface{})
+	if !ok {
 		return nil, types.NewError(
-			types.ErrDecodingFailure,
-			"failed to parse stdin to plain interface",
-			err.Error(),
+			types.ErrInvalidNetworkConfig,
+			"stdin must have a plugins array to use pluginIndex or pluginSelector",
+			"",
 		)
 	}
 
-	merger := &bytes.Buffer{}
-	if err = tmpl.Execute(merger, rawConf); err != nil {
+	index, cniErr := conf.pluginEntry(plugins)
+	if cniErr != nil {
+		return nil, cniErr
+	}
+
+	entry, err := json.Marshal(plugins[index])
+	if err != nil {
+		return nil, types.NewError(ErrMergeJSONFailed, "failed to merge downstream config into plugins", err.Error())
+	}
+	merged, err := jsonpatch.MergePatch(entry, downstream)
+	if err != nil {
 		return nil, types.NewError(
-			ErrInvalidPatchTemplate,
-			"failed to execute template for JSON merge patch",
+			ErrMergeJSONFailed,
+			fmt.Sprintf("failed to merge downstream config into plugins[%d]", index),
 			err.Error(),
 		)
 	}
+	var mergedEntry interface{}
+	if err := json.Unmarshal(merged, &mergedEntry); err != nil {
+		return nil, types.NewError(ErrMergeJSONFailed, "failed to merge downstream config into plugins", err.Error())
+	}
+	plugins[index] = mergedEntry
 
-	cleanup := fmt.Sprintf(`{"type": "%s", "plugin": null, "config": null, "patch": null}`, conf.Plugin)
-	cleaned, err := jsonpatch.MergePatch(stdin, []byte(cleanup))
+	out, err := json.Marshal(conflist)
+	if err != nil {
+		return nil, types.NewError(ErrMergeJSONFailed, "failed to merge downstream config into plugins", err.Error())
+	}
+	return out, nil
+}
+
+// pluginEntry returns the index of the entry in plugins which is targeted by
+// [PluginConfig.PluginIndex] or [PluginConfig.PluginSelector]. A selector must
+// match exactly one entry.
+func (conf *PluginConfig) pluginEntry(plugins []interface{}) (int, *types.Error) {
+	if conf.PluginIndex != nil {
+		index := *conf.PluginIndex
+		if index < 0 || index >= len(plugins) {
+			return 0, types.NewError(
+				types.ErrInvalidNetworkConfig,
+				fmt.Sprintf("pluginIndex %d is out of range", index),
+				fmt.Sprintf("there are %d plugins", len(plugins)),
+			)
+		}
+		return index, nil
+	}
+
+	matches := []int{}
+	for i, p := range plugins {
+		entry, ok := p.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		matched := true
+		for k, v := range conf.PluginSelector {
+			if fmt.Sprint(entry[k]) != fmt.Sprint(v) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			matches = append(matches, i)
+		}
+	}
+	if len(matches) != 1 {
+		return 0, types.NewError(
+			types.ErrInvalidNetworkConfig,
+			"pluginSelector must match exactly one plugin",
+			fmt.Sprintf("matched %d plugins: %v", len(matches), matches),
+		)
+	}
+	return matches[0], nil
+}
+
+// restoreKeys returns config with each of the top-level keys set to its value
+// in original. Keys that are not in original are removed.
+func restoreKeys(original, config []byte, keys []string) ([]byte, *types.Error) {
+	if len(keys) == 0 {
+		return config, nil
+	}
+
+	orig := map[string]json.RawMessage{}
+	restored := map[string]json.RawMessage{}
+	if err := json.Unmarshal(original, &orig); err != nil {
+		return nil, types.NewError(ErrMergeJSONFailed, "failed to restore protected keys", err.Error())
+	}
+	if err := json.Unmarshal(config, &restored); err != nil {
+		return nil, types.NewError(ErrMergeJSONFailed, "failed to restore protected keys", err.Error())
+	}
+
+	for _, k := range keys {
+		if v, ok := orig[k]; ok {
+			restored[k] = v
+		} else {
+			delete(restored, k)
+		}
+	}
+
+	out, err := json.Marshal(restored)
+	if err != nil {
+		return nil, types.NewError(ErrMergeJSONFailed, "failed to restore protected keys", err.Error())
+	}
+	return out, nil
+}
+
+// cleanStdin returns stdin with gator's configuration removed, and the type set
+// to the downstream plugin.
+func cleanStdin(conf *PluginConfig) ([]byte, *types.Error) {
+	cleaned, err := jsonpatch.MergePatch(conf.stdin, cleanupPatch(conf.Plugin))
 	if err != nil {
 		return nil, types.NewError(
 			ErrMergeJSONFailed,
@@ -175,80 +1265,418 @@ func generateDownstream(conf *PluginConfig) ([]byte, *types.Error) {
 			err.Error(),
 		)
 	}
+	return cleaned, nil
+}
 
-	// Allow no-op configs
-	downstreamConf := []byte("{}")
-	if conf.Config != nil {
-		downstreamConf = *conf.Config
+// applyFinalPatch executes the [PluginConfig.FinalPatch] template with data,
+// plus the merged config as .Merged, and merges the result onto config.
+func applyFinalPatch(text string, data map[string]interface{}, opts funcOptions, config []byte) ([]byte, *types.Error) {
+	var merged interface{}
+	if err := json.Unmarshal(config, &merged); err != nil {
+		return nil, types.NewError(ErrMergeJSONFailed, "failed to parse the merged config", err.Error())
+	}
+	finalData := make(map[string]interface{}, len(data)+1)
+	for k, v := range data {
+		finalData[k] = v
+	}
+	finalData["Merged"] = merged
+
+	patch, cniErr := executeTemplate("conf.FinalPatch", text, finalData, opts)
+	if cniErr != nil {
+		return nil, cniErr
+	}
+	if len(bytes.TrimSpace(patch)) == 0 {
+		return config, nil
+	}
+	patched, err := jsonpatch.MergePatch(config, patch)
+	if err != nil {
+		return nil, types.NewError(
+			ErrMergeJSONFailed,
+			"failed to merge finalPatch with the downstream config",
+			err.Error(),
+		)
+	}
+	return patched, nil
+}
+
+// renderSkip executes each entry of [PluginConfig.Skip] as a template, and
+// returns the commands which they render to. Empty entries are dropped.
+func renderSkip(conf *PluginConfig) ([]string, *types.Error) {
+	if len(conf.Skip) == 0 {
+		return nil, nil
+	}
+	data := templateData(conf)
+	skip := make([]string, 0, len(conf.Skip))
+	for i, entry := range conf.Skip {
+		out, err := executeTemplate(fmt.Sprintf("conf.Skip[%d]", i), entry, data, conf.funcOptions())
+		if err != nil {
+			return nil, err
+		}
+		if command := strings.TrimSpace(string(out)); command != "" {
+			skip = append(skip, command)
+		}
+	}
+	return skip, nil
+}
+
+// templateData returns the data which templates are executed with: stdin as
+// a plain interface, plus the following well-known keys:
+//
+//   - Raw: stdin as an unparsed string
+//   - Command: the CNI_COMMAND, such as ADD or DEL
+//
+// stdin has already been decoded by [decodeConf], so it is not parsed again.
+// The returned map is a copy, which callers may add their own keys to.
+func templateData(conf *PluginConfig) map[string]interface{} {
+	data := make(map[string]interface{}, len(conf.data)+4)
+	for k, v := range conf.data {
+		data[k] = v
+	}
+	data["Raw"] = string(conf.stdin)
+	data["Command"] = os.Getenv("CNI_COMMAND")
+	data["DownstreamVersions"] = conf.downstreamVersions
+	data["PrevResults"] = prevResultsOf(conf.data)
+	return data
+}
+
+// renderTemplates executes the templates in conf with data, and returns the
+// rendered patch and downstream config.
+func renderTemplates(conf *PluginConfig, data interface{}) (patch, downstreamConf []byte, err *types.Error) {
+	opts := conf.funcOptions()
+	patch, err = executeTemplate("conf.Patch", conf.Patch, data, opts)
+	if err != nil {
+		return nil, nil, err
 	}
-	patch := merger.Bytes()
 	if len(patch) == 0 {
 		patch = []byte("{}")
 	}
+	if patch, err = layerCommandPatch("conf.CommandPatches", conf.CommandPatches, patch, data, opts); err != nil {
+		return nil, nil, err
+	}
+	if conf.PatchUnderPluginKey {
+		if patch, err = wrapPatch(conf.Plugin, patch); err != nil {
+			return nil, nil, err
+		}
+	}
 
-	downstream, err := jsonpatch.MergePatch(downstreamConf, patch)
+	if downstreamConf, err = baseConfig(conf); err != nil {
+		return nil, nil, err
+	}
+	if isTemplate(downstreamConf) {
+		downstreamConf, err = executeTemplate("conf.Config", string(downstreamConf), data, opts)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return patch, downstreamConf, nil
+}
+
+// layerCommandPatch renders the entry in patches for the CNI_COMMAND, if there
+// is one, and merges it on top of patch so that it takes precedence over the
+// generic patch. name is the name of patches, for errors.
+func layerCommandPatch(name string, patches map[string]string, patch []byte, data interface{}, opts funcOptions) ([]byte, *types.Error) {
+	command := os.Getenv("CNI_COMMAND")
+	text, ok := patches[command]
+	if !ok {
+		return patch, nil
+	}
+	commandPatch, err := executeTemplate(name+"."+command, text, data, opts)
 	if err != nil {
+		return nil, err
+	}
+	if len(commandPatch) == 0 {
+		return patch, nil
+	}
+	layered, mergeErr := jsonpatch.MergeMergePatches(patch, commandPatch)
+	if mergeErr != nil {
 		return nil, types.NewError(
 			ErrMergeJSONFailed,
-			"failed to merge patch with downstream config",
-			err.Error(),
+			fmt.Sprintf("failed to merge %s.%s on top of the generic patch", name, command),
+			mergeErr.Error(),
 		)
 	}
+	return layered, nil
+}
+
+// baseConfig returns the downstream config which the patch is applied to, from
+// either [PluginConfig.Config] or [PluginConfig.ConfigFile].
+func baseConfig(conf *PluginConfig) ([]byte, *types.Error) {
+	if conf.ConfigFile != "" {
+		b, err := os.ReadFile(conf.ConfigFile)
+		if err != nil {
+			return nil, types.NewError(
+				types.ErrIOFailure,
+				"failed to read configFile",
+				err.Error(),
+			)
+		}
+		return b, nil
+	}
+
+	if conf.Config != nil {
+		return *conf.Config, nil
+	}
+
+	// Allow no-op configs
+	return []byte("{}"), nil
+}
 
-	finalConfig, err := jsonpatch.MergePatch(cleaned, downstream)
+// wrapPatch returns patch nested under key, after checking that it is a JSON
+// object.
+func wrapPatch(key string, patch []byte) ([]byte, *types.Error) {
+	obj := map[string]json.RawMessage{}
+	if err := json.Unmarshal(patch, &obj); err != nil {
+		return nil, types.NewError(
+			ErrInvalidPatchTemplate,
+			"patch must be a JSON object to be merged under the plugin key",
+			err.Error(),
+		)
+	}
+	wrapped, err := json.Marshal(map[string]interface{}{key: obj})
 	if err != nil {
 		return nil, types.NewError(
 			ErrMergeJSONFailed,
-			"failed to merge downstream config with original",
+			"failed to wrap patch under the plugin key",
 			err.Error(),
 		)
 	}
+	return wrapped, nil
+}
 
-	return finalConfig, nil
+// isTemplate returns true if text contains template actions.
+func isTemplate(text []byte) bool {
+	return bytes.Contains(text, []byte("{{"))
+}
+
+// executeTemplate parses text as a template called name and executes it with
+// data, and the functions from [funcMap].
+func executeTemplate(name, text string, data interface{}, opts funcOptions) ([]byte, *types.Error) {
+	tmpl, err := template.New(name).Funcs(funcMap(data, opts)).Parse(text)
+	if err != nil {
+		return nil, types.NewError(
+			types.ErrDecodingFailure,
+			fmt.Sprintf("failed to parse template: %s", name),
+			err.Error(),
+		)
+	}
+
+	out := &bytes.Buffer{}
+	if err = tmpl.Execute(out, data); err != nil {
+		return nil, types.NewError(
+			ErrInvalidPatchTemplate,
+			fmt.Sprintf("failed to execute template: %s", name),
+			err.Error(),
+		)
+	}
+
+	// Missing values render as empty strings rather than "<no value>", as they
+	// did with html/template
+	return bytes.ReplaceAll(out.Bytes(), []byte("<no value>"), nil), nil
 }
 
-func delegate(pluginPath string, stdin []byte, env []string) (stdout []byte, stderr []byte, exitcode int) {
+// cleanupPatch returns a JSON merge patch which removes gator's configuration
+// from stdin and sets the type to the downstream plugin.
+func cleanupPatch(plugin string) []byte {
+	cleanup := map[string]interface{}{"type": plugin}
+	for _, k := range metaKeys {
+		cleanup[k] = nil
+	}
+	b, _ := json.Marshal(cleanup)
+	return b
+}
+
+func delegate(ctx context.Context, pluginPath string, stdin []byte, env []string, attr *syscall.SysProcAttr) (stdout []byte, stderr []byte, exitcode int) {
 	fout := &bytes.Buffer{}
 	ferr := &bytes.Buffer{}
 
-	cmd := exec.Command(pluginPath)
+	cmd := exec.CommandContext(ctx, pluginPath)
+	cmd.WaitDelay = waitDelay
+	cmd.SysProcAttr = attr
 	cmd.Env = env
 	cmd.Stdin = bytes.NewReader(stdin)
 	cmd.Stdout = fout
 	cmd.Stderr = ferr
 
+	start := time.Now()
 	if err := cmd.Run(); err != nil {
-		if exiterr, ok := err.(*exec.ExitError); ok {
+		exiterr, ok := err.(*exec.ExitError)
+		switch {
+		case ok && exiterr.ExitCode() >= 0:
 			exitcode = exiterr.ExitCode()
+		case ok:
+			// The plugin was killed by a signal, so it has no exit code to pass
+			// through, and anything it printed is incomplete
+			exitcode = ErrPluginSignaled
+			fout.Reset()
+			fout.WriteString(errorJSON(types.NewError(
+				ErrPluginSignaled,
+				fmt.Sprintf("plugin was terminated: %s", filepath.Base(pluginPath)),
+				exiterr.String(),
+			)))
+		default:
+			// The plugin could not be started at all, so gator reports the
+			// error as it would any of its own
+			exitcode = ErrExecFailed
+			details := err.Error()
+			if attr != nil && errors.Is(err, os.ErrPermission) {
+				details += " (gator may not be allowed to change to runAsUser or runAsGroup)"
+			}
+			fmt.Fprint(ferr, details)
+			fout.Reset()
+			fout.WriteString(errorJSON(types.NewError(
+				ErrExecFailed,
+				fmt.Sprintf("failed to execute plugin: %s", filepath.Base(pluginPath)),
+				details,
+			)))
 		}
 	}
 
+	if exitcode != 0 {
+		logDelegateFailure(pluginPath, env, exitcode, time.Since(start), ferr.Bytes())
+	}
+
 	return fout.Bytes(), ferr.Bytes(), exitcode
 }
 
+// checkAllowedPlugin returns an error if [PluginConfig.AllowedPlugins] is set
+// and does not include the plugin.
+func (conf *PluginConfig) checkAllowedPlugin() *types.Error {
+	if conf.isAllowed(conf.Plugin) {
+		return nil
+	}
+	return types.NewError(
+		ErrPluginNotAllowed,
+		fmt.Sprintf("plugin is not allowed: %s", conf.Plugin),
+		fmt.Sprintf("allowed: %v", conf.AllowedPlugins),
+	)
+}
+
+// defaultRequireEnvExempt are the commands for which
+// [PluginConfig.RequireEnv] is not checked, unless RequireEnvExempt is set.
+var defaultRequireEnvExempt = []string{"DEL", "GC", "STATUS"}
+
+// checkRequiredEnv returns an error if any of [PluginConfig.RequireEnv] are
+// empty, unless the CNI_COMMAND is exempt.
+func (conf *PluginConfig) checkRequiredEnv() *types.Error {
+	exempt := conf.RequireEnvExempt
+	if exempt == nil {
+		exempt = defaultRequireEnvExempt
+	}
+	if len(conf.RequireEnv) == 0 || slices.Contains(exempt, os.Getenv("CNI_COMMAND")) {
+		return nil
+	}
+	missing := []string{}
+	for _, key := range conf.RequireEnv {
+		if os.Getenv(key) == "" {
+			missing = append(missing, key)
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	return types.NewError(
+		types.ErrInvalidEnvironmentVariables,
+		fmt.Sprintf("required environment variables are empty: %s", strings.Join(missing, ", ")),
+		fmt.Sprintf("CNI_COMMAND: %s", os.Getenv("CNI_COMMAND")),
+	)
+}
+
+// downstreamLogLevelEnv returns the value of
+// [PluginConfig.DownstreamLogLevelEnv], which defaults to CNI_LOG_LEVEL.
+func (conf *PluginConfig) downstreamLogLevelEnv() string {
+	if conf.DownstreamLogLevelEnv == "" {
+		return "CNI_LOG_LEVEL"
+	}
+	return conf.DownstreamLogLevelEnv
+}
+
+// isIdentity returns true if [PluginConfig.Identity] or GATOR_IDENTITY is set.
+func (conf *PluginConfig) isIdentity() (bool, *types.Error) {
+	v := os.Getenv("GATOR_IDENTITY")
+	if conf.Identity || v == "" {
+		return conf.Identity, nil
+	}
+	identity, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, types.NewError(
+			types.ErrInvalidEnvironmentVariables,
+			"GATOR_IDENTITY must be a boolean",
+			v,
+		)
+	}
+	return identity, nil
+}
+
+// suppressPartialResult returns the value of
+// [PluginConfig.SuppressPartialResult], which defaults to true.
+func (conf *PluginConfig) suppressPartialResult() bool {
+	return conf.SuppressPartialResult == nil || *conf.SuppressPartialResult
+}
+
+// downstreamError returns what should be printed to stdout when the plugin
+// exited with a non-zero exitcode. If the plugin printed a CNI error, it is
+// returned as is. Otherwise, anything it printed is logged and replaced with a
+// CNI error.
+func downstreamError(plugin string, stdout []byte, exitcode int) []byte {
+	cniErr := &types.Error{}
+	if err := json.Unmarshal(stdout, cniErr); err == nil && cniErr.Code != 0 {
+		return stdout
+	}
+
+	if len(bytes.TrimSpace(stdout)) > 0 {
+		logger.Warn("suppressed partial result from downstream plugin",
+			"plugin", plugin,
+			"stdout", string(stdout),
+		)
+	}
+
+	out, _ := json.Marshal(types.NewError(
+		uint(exitcode),
+		fmt.Sprintf("downstream plugin failed: %s", plugin),
+		fmt.Sprintf("exit code %d", exitcode),
+	))
+	return out
+}
+
+// getPluginPath returns the path of plugin in CNI_PATH. If GATOR_PATH_CACHE is
+// set, paths are cached in that file until they no longer exist.
 func getPluginPath(plugin string) (string, *types.Error) {
-	cniPaths := []string{"/opt/cni/bin"}
+	cacheFile := os.Getenv("GATOR_PATH_CACHE")
+	if cacheFile == "" {
+		return resolvePluginPath(plugin)
+	}
+	key := plugin + "@" + os.Getenv("CNI_PATH")
+	if p, ok := readPathCache(cacheFile)[key]; ok && isExecutable(p) {
+		return p, nil
+	}
+	p, err := resolvePluginPath(plugin)
+	if err != nil {
+		return "", err
+	}
+	writePathCache(cacheFile, key, p)
+	return p, nil
+}
+
+// cniPaths returns the directories in CNI_PATH, or the default of /opt/cni/bin.
+func cniPaths() []string {
 	if cniPathVar := os.Getenv("CNI_PATH"); cniPathVar != "" {
-		cniPaths = strings.Split(cniPathVar, ":")
+		return strings.Split(cniPathVar, ":")
 	}
+	return []string{"/opt/cni/bin"}
+}
 
+// resolvePluginPath returns the first executable named plugin in CNI_PATH.
+func resolvePluginPath(plugin string) (string, *types.Error) {
+	cniPaths := cniPaths()
 	for _, p := range cniPaths {
 		fullPath := filepath.Join(p, plugin)
-		f, err := os.Open(fullPath)
-		if err != nil {
-			continue
-		}
-		s, err := f.Stat()
-		if err != nil {
-			continue
-		}
-		// Check if file is executable by someone
-		if s.Mode()&0111 != 0 {
+		if isExecutable(fullPath) {
 			return fullPath, nil
 		}
 	}
 	return "", types.NewError(
-		ErrMergeJSONFailed,
+		ErrPluginNotFound,
 		fmt.Sprintf("cni executable not found in CNI_PATH: %s", plugin),
 		fmt.Sprintf("checked: %v", cniPaths),
 	)
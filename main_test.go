@@ -2,14 +2,20 @@ package main
 
 import (
 	"bytes"
+	"crypto/sha256"
 	"encoding/json"
 	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
 
+	"github.com/containernetworking/cni/pkg/types"
 	jsonpatch "github.com/evanphx/json-patch"
 )
 
-func ExamplePluginNoOp() {
+func Example_pluginNoOp() {
 	stdin := []byte(`{"type": "gator", "plugin": "debug", "prevResult": {"key": "value"}}`)
 	conf, _ := parseConf(stdin)
 	out, _ := formatTestJSON(conf.downstreamConfig)
@@ -24,7 +30,7 @@ func ExamplePluginNoOp() {
 	// }
 }
 
-func ExamplePluginRouteOverride() {
+func Example_pluginRouteOverride() {
 	stdin, _ := mergePrevResult("testdata/route-override.json")
 	conf, _ := parseConf(stdin)
 	out, _ := formatTestJSON(conf.downstreamConfig)
@@ -79,9 +85,10 @@ func ExamplePluginRouteOverride() {
 
 }
 
-func ExamplePluginDebug() {
-	// This debug.json file's patch is time-based. This test will have to be
-	// updated each year.
+func Example_pluginDebug() {
+	// This debug.json file's patch is time-based, so the clock is pinned.
+	defer func(orig func() time.Time) { clock = orig }(clock)
+	clock = func() time.Time { return time.Date(2023, 10, 3, 0, 0, 0, 0, time.UTC) }
 	stdin, _ := mergePrevResult("testdata/debug.json")
 	conf, _ := parseConf(stdin)
 	out, _ := formatTestJSON(conf.downstreamConfig)
@@ -96,7 +103,7 @@ func ExamplePluginDebug() {
 	//       "ip link set $CNI_IFNAME promisc on"
 	//     ]
 	//   ],
-	//   "cniOutput": "/tmp/cni-output-2024.log",
+	//   "cniOutput": "/tmp/cni-output-2023.log",
 	//   "prevResult": {
 	//     "cniVersion": "0.3.1",
 	//     "dns": {},
@@ -138,6 +145,46 @@ func ExamplePluginDebug() {
 
 }
 
+func TestTemplateQuotedValue(t *testing.T) {
+	// Without HTML escaping, a quote in a value ends the string it is
+	// interpolated into, so the patch is no longer valid JSON
+	stdin := []byte(`{"type": "gator", "plugin": "debug", "args": {"app": "a\"b"}, "patch": "{\"app\": \"{{ .args.app }}\"}"}`)
+	if _, err := parseConf(stdin); err == nil {
+		t.Fatal("expected an error for a value which breaks out of its quotes")
+	}
+
+	stdin = []byte(`{"type": "gator", "plugin": "debug", "args": {"app": "a\"b"}, "patch": "{\"app\": {{ toJson .args.app }}, \"missing\": \"{{ .args.missing }}\"}"}`)
+	conf, err := parseConf(stdin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := map[string]interface{}{}
+	if err := json.Unmarshal(conf.downstreamConfig, &out); err != nil {
+		t.Fatal(err)
+	}
+	if out["app"] != `a"b` || out["missing"] != "" {
+		t.Fatalf("expected toJson to quote the value and missing values to be empty, got %s", conf.downstreamConfig)
+	}
+}
+
+func TestTemplateNotHTMLEscaped(t *testing.T) {
+	stdin := []byte(`{"type": "gator", "plugin": "debug", "args": {"labels": {"app": "a&b<c>"}}, "patch": "{\"labels\": {{ toJson .args.labels }}, \"quote\": \"{{ .args.labels.app }}\"}"}`)
+	conf, err := parseConf(stdin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := struct {
+		Labels map[string]string
+		Quote  string
+	}{}
+	if err := json.Unmarshal(conf.downstreamConfig, &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.Labels["app"] != "a&b<c>" || out.Quote != "a&b<c>" {
+		t.Fatalf("expected values not to be HTML-escaped, got %s", conf.downstreamConfig)
+	}
+}
+
 func mergePrevResult(file string) ([]byte, error) {
 	conf, err := os.ReadFile(file)
 	if err != nil {
@@ -148,7 +195,11 @@ func mergePrevResult(file string) ([]byte, error) {
 		return nil, err
 	}
 
-	return jsonpatch.MergePatch(conf, prevResult)
+	out, cniErr := injectPrevResult(conf, prevResult)
+	if cniErr != nil {
+		return nil, cniErr
+	}
+	return out, nil
 }
 
 func formatTestJSON(j []byte) ([]byte, error) {
@@ -158,3 +209,844 @@ func formatTestJSON(j []byte) ([]byte, error) {
 	}
 	return b.Bytes(), nil
 }
+
+// writeFakePlugin writes an executable shell script named name into dir and
+// returns its full path.
+func writeFakePlugin(t *testing.T, dir, name, script string) string {
+	t.Helper()
+	p := filepath.Join(dir, name)
+	if err := os.WriteFile(p, []byte("#!/bin/sh\n"+script+"\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestParseConfAllowedPlugins(t *testing.T) {
+	stdin := []byte(`{"type": "gator", "plugin": "debug", "allowedPlugins": ["debug", "route-override"]}`)
+	if _, err := parseConf(stdin); err != nil {
+		t.Fatalf("expected allowed plugin to be accepted, got %v", err)
+	}
+
+	stdin = []byte(`{"type": "gator", "plugin": "bridge", "allowedPlugins": ["debug", "route-override"]}`)
+	_, err := parseConf(stdin)
+	if err == nil {
+		t.Fatal("expected disallowed plugin to be rejected")
+	}
+	if err.Code != ErrPluginNotAllowed {
+		t.Fatalf("expected code %d, got %d", ErrPluginNotAllowed, err.Code)
+	}
+}
+
+func TestRunDryRunPrevResult(t *testing.T) {
+	stdin, err := os.Open("testdata/route-override.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stdin.Close()
+
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	args := []string{"--dry-run", "--prev-result", "testdata/prevresult.json"}
+	if code := run(args, stdin, stdout, stderr); code != 0 {
+		t.Fatalf("expected exit code 0, got %d: %s", code, stderr)
+	}
+
+	merged, err := mergePrevResult("testdata/route-override.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf, cniErr := parseConf(merged)
+	if cniErr != nil {
+		t.Fatal(cniErr)
+	}
+	if got, want := bytes.TrimSpace(stdout.Bytes()), conf.downstreamConfig; !bytes.Equal(got, want) {
+		t.Fatalf("unexpected dry run output:\ngot:  %s\nwant: %s", got, want)
+	}
+}
+
+func TestRunDryRunOutput(t *testing.T) {
+	stdin, err := os.Open("testdata/route-override.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stdin.Close()
+
+	path := filepath.Join(t.TempDir(), "downstream.json")
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	args := []string{"--dry-run", "--output", path, "--prev-result", "testdata/prevresult.json"}
+	if code := run(args, stdin, stdout, stderr); code != 0 {
+		t.Fatalf("expected exit code 0, got %d: %s", code, stderr)
+	}
+	if stdout.Len() != 0 {
+		t.Fatalf("expected no output on stdout, got: %s", stdout)
+	}
+
+	merged, err := mergePrevResult("testdata/route-override.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf, cniErr := parseConf(merged)
+	if cniErr != nil {
+		t.Fatal(cniErr)
+	}
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := append(conf.downstreamConfig, '\n'); !bytes.Equal(got, want) {
+		t.Fatalf("unexpected output file:\ngot:  %s\nwant: %s", got, want)
+	}
+
+	args = []string{"--output", path}
+	if code := run(args, bytes.NewReader(nil), stdout, stderr); code != 2 {
+		t.Fatalf("expected exit code 2 without --dry-run, got %d", code)
+	}
+}
+
+func TestRunPrevResultRequiresDryRun(t *testing.T) {
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	args := []string{"--prev-result", "testdata/prevresult.json"}
+	if code := run(args, bytes.NewReader(nil), stdout, stderr); code != 2 {
+		t.Fatalf("expected exit code 2, got %d", code)
+	}
+}
+
+func TestRunErrorsOnStdout(t *testing.T) {
+	dir := t.TempDir()
+	writeFakePlugin(t, dir, "ok", `echo '{}'`)
+	if err := os.WriteFile(filepath.Join(dir, "broken"), []byte("#!/nonexistent/sh\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("CNI_PATH", dir)
+	t.Setenv("CNI_COMMAND", "ADD")
+
+	tests := map[string]struct {
+		stdin string
+		env   map[string]string
+		code  int
+	}{
+		"invalid JSON": {
+			stdin: `{`,
+			code:  int(types.ErrDecodingFailure),
+		},
+		"invalid template": {
+			stdin: `{"type": "gator", "plugin": "ok", "patch": "{{ fail \"no\" }}"}`,
+			code:  ErrInvalidPatchTemplate,
+		},
+		"plugin not allowed": {
+			stdin: `{"type": "gator", "plugin": "ok", "allowedPlugins": ["bridge"]}`,
+			code:  ErrPluginNotAllowed,
+		},
+		"plugin not found": {
+			stdin: `{"type": "gator", "plugin": "missing"}`,
+			code:  ErrPluginNotFound,
+		},
+		"plugin cannot be executed": {
+			stdin: `{"type": "gator", "plugin": "broken"}`,
+			code:  ErrExecFailed,
+		},
+		"preExec fails": {
+			stdin: `{"type": "gator", "plugin": "ok", "preExec": ["false"]}`,
+			code:  ErrPreExecFailed,
+		},
+		"stdin too large": {
+			stdin: `{"type": "gator", "plugin": "ok"}`,
+			env:   map[string]string{"GATOR_MAX_STDIN_BYTES": "8"},
+			code:  int(types.ErrIOFailure),
+		},
+		"invalid deadline": {
+			stdin: `{"type": "gator", "plugin": "ok"}`,
+			env:   map[string]string{"GATOR_DEADLINE": "soon"},
+			code:  int(types.ErrInvalidEnvironmentVariables),
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+			if code := run(nil, bytes.NewBufferString(tt.stdin), stdout, stderr); code != tt.code {
+				t.Fatalf("expected exit code %d, got %d: %s", tt.code, code, stdout)
+			}
+			cniErr := &types.Error{}
+			if err := json.Unmarshal(stdout.Bytes(), cniErr); err != nil {
+				t.Fatalf("expected a CNI error on stdout, got %q: %v", stdout, err)
+			}
+			if int(cniErr.Code) != tt.code || cniErr.Msg == "" {
+				t.Fatalf("expected a CNI error with code %d, got %+v", tt.code, cniErr)
+			}
+		})
+	}
+}
+
+func TestRunMaxStdinBytes(t *testing.T) {
+	t.Setenv("GATOR_MAX_STDIN_BYTES", "16")
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	stdin := strings.NewReader(`{"type": "gator", "plugin": "debug"}`)
+	if code := run(nil, stdin, stdout, stderr); code != int(types.ErrIOFailure) {
+		t.Fatalf("expected exit code %d, got %d: %s", types.ErrIOFailure, code, stderr)
+	}
+	if !strings.Contains(stdout.String(), "stdin is too large") {
+		t.Fatalf("expected stdin to be too large, got: %s", stdout)
+	}
+
+	t.Setenv("GATOR_MAX_STDIN_BYTES", "lots")
+	stdin = strings.NewReader(`{}`)
+	if code := run(nil, stdin, stdout, stderr); code != int(types.ErrInvalidEnvironmentVariables) {
+		t.Fatalf("expected exit code %d, got %d: %s", types.ErrInvalidEnvironmentVariables, code, stderr)
+	}
+}
+
+func TestRunSuppressPartialResult(t *testing.T) {
+	dir := t.TempDir()
+	writeFakePlugin(t, dir, "partial", `echo '{"cniVersion": "1.0.0", "ips": []}'; exit 4`)
+	writeFakePlugin(t, dir, "cnierror", `echo '{"code": 7, "msg": "no addresses"}'; exit 7`)
+	t.Setenv("CNI_PATH", dir)
+
+	tests := []struct {
+		name     string
+		stdin    string
+		exitcode int
+		code     uint
+	}{
+		{
+			name:     "partial result is suppressed by default",
+			stdin:    `{"type": "gator", "plugin": "partial"}`,
+			exitcode: 4,
+			code:     4,
+		},
+		{
+			name:     "CNI error is passed through",
+			stdin:    `{"type": "gator", "plugin": "cnierror"}`,
+			exitcode: 7,
+			code:     7,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+			if code := run(nil, bytes.NewBufferString(tt.stdin), stdout, stderr); code != tt.exitcode {
+				t.Fatalf("expected exit code %d, got %d", tt.exitcode, code)
+			}
+			cniErr := map[string]interface{}{}
+			if err := json.Unmarshal(stdout.Bytes(), &cniErr); err != nil {
+				t.Fatalf("stdout is not JSON: %q", stdout)
+			}
+			if _, ok := cniErr["ips"]; ok {
+				t.Fatalf("partial result was not suppressed: %s", stdout)
+			}
+			if cniErr["code"] != float64(tt.code) {
+				t.Fatalf("expected code %d, got %v", tt.code, cniErr["code"])
+			}
+		})
+	}
+
+	t.Run("partial result is logged", func(t *testing.T) {
+		logs := captureLogs(t)
+		stdin := `{"type": "gator", "plugin": "partial"}`
+		if code := run(nil, bytes.NewBufferString(stdin), &bytes.Buffer{}, &bytes.Buffer{}); code != 4 {
+			t.Fatalf("expected exit code 4, got %d", code)
+		}
+		for _, line := range strings.Split(logs.String(), "\n") {
+			entry := map[string]interface{}{}
+			json.Unmarshal([]byte(line), &entry)
+			if entry["msg"] == "suppressed partial result from downstream plugin" {
+				if entry["stdout"] != "{\"cniVersion\": \"1.0.0\", \"ips\": []}\n" {
+					t.Fatalf("unexpected stdout in the log: %v", entry["stdout"])
+				}
+				return
+			}
+		}
+		t.Fatalf("expected the partial result to be logged, got %s", logs)
+	})
+
+	t.Run("partial result is printed when disabled", func(t *testing.T) {
+		stdin := `{"type": "gator", "plugin": "partial", "suppressPartialResult": false}`
+		stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+		if code := run(nil, bytes.NewBufferString(stdin), stdout, stderr); code != 4 {
+			t.Fatalf("expected exit code 4, got %d", code)
+		}
+		if got := stdout.String(); got != "{\"cniVersion\": \"1.0.0\", \"ips\": []}\n" {
+			t.Fatalf("unexpected stdout: %q", got)
+		}
+	})
+}
+
+func Example_templatedConfig() {
+	stdin := []byte(`{
+		"type": "gator",
+		"plugin": "route-override",
+		"config": {
+			"addroutes": [{"dst": "10.96.0.0/16", "gw": "{{with $n := index .prevResult.ips 0}}{{$n.gateway}}{{end}}"}],
+			"comment": "{{ printf ` + "`%s via %s`" + ` .type .plugin }}"
+		},
+		"prevResult": {"ips": [{"address": "10.244.1.42/24", "gateway": "10.244.1.1"}]}
+	}`)
+	conf, _ := parseConf(stdin)
+	out, _ := formatTestJSON(conf.downstreamConfig)
+	fmt.Println(string(out))
+
+	// Output:
+	// {
+	//   "addroutes": [
+	//     {
+	//       "dst": "10.96.0.0/16",
+	//       "gw": "10.244.1.1"
+	//     }
+	//   ],
+	//   "comment": "gator via route-override",
+	//   "prevResult": {
+	//     "ips": [
+	//       {
+	//         "address": "10.244.1.42/24",
+	//         "gateway": "10.244.1.1"
+	//       }
+	//     ]
+	//   },
+	//   "type": "route-override"
+	// }
+}
+
+func TestTemplateRaw(t *testing.T) {
+	stdin := []byte(`{"type": "gator", "plugin": "debug", "patch": "{\"hash\": \"{{ sha256sum .Raw }}\"}"}`)
+	conf, err := parseConf(stdin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := map[string]interface{}{}
+	if err := json.Unmarshal(conf.downstreamConfig, &out); err != nil {
+		t.Fatal(err)
+	}
+	if want := fmt.Sprintf("%x", sha256.Sum256(stdin)); out["hash"] != want {
+		t.Fatalf("expected hash %s, got %v", want, out["hash"])
+	}
+}
+
+func Example_patchObject() {
+	stdin, _ := mergePrevResult("testdata/route-override.json")
+	conf := map[string]interface{}{}
+	_ = json.Unmarshal(stdin, &conf)
+	conf["patch"] = map[string]interface{}{
+		"addroutes": []interface{}{
+			map[string]interface{}{"dst": "10.96.0.0/16", "gw": "{{ defaultGateway 4 }}"},
+		},
+	}
+	stdin, _ = json.Marshal(conf)
+	parsed, err := parseConf(stdin)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	printKeys(parsed.downstreamConfig, "addroutes")
+
+	_, err = parseConf([]byte(`{"type": "gator", "plugin": "debug", "patch": ["not", "a", "patch"]}`))
+	fmt.Println(err.Msg)
+
+	// Output:
+	// addroutes: [{"dst":"10.96.0.0/16","gw":"10.244.1.1"}]
+	// failed to parse JSON config
+}
+
+func TestTemplateCommand(t *testing.T) {
+	stdin := []byte(`{"type": "gator", "plugin": "debug", "patch": "{{ if eq .Command \"ADD\" }}{\"promisc\": true}{{ else }}{}{{ end }}"}`)
+	for command, want := range map[string]interface{}{"ADD": true, "DEL": nil} {
+		t.Run(command, func(t *testing.T) {
+			t.Setenv("CNI_COMMAND", command)
+			conf, err := parseConf(stdin)
+			if err != nil {
+				t.Fatal(err)
+			}
+			out := map[string]interface{}{}
+			if err := json.Unmarshal(conf.downstreamConfig, &out); err != nil {
+				t.Fatal(err)
+			}
+			if out["promisc"] != want {
+				t.Fatalf("expected promisc %v, got %v", want, out["promisc"])
+			}
+		})
+	}
+}
+
+func TestCommandPatches(t *testing.T) {
+	stdin := []byte(`{
+		"type": "gator",
+		"plugin": "debug",
+		"patch": "{\"cniOutput\": \"/tmp/cni.log\", \"promisc\": false}",
+		"commandPatches": {"ADD": "{\"promisc\": {{ eq .Command \"ADD\" }}}"}
+	}`)
+	tests := map[string]string{
+		"ADD": `{"cniOutput":"/tmp/cni.log","promisc":true,"type":"debug"}`,
+		"DEL": `{"cniOutput":"/tmp/cni.log","promisc":false,"type":"debug"}`,
+	}
+	for command, want := range tests {
+		t.Run(command, func(t *testing.T) {
+			t.Setenv("CNI_COMMAND", command)
+			conf, err := parseConf(stdin)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(conf.downstreamConfig) != want {
+				t.Fatalf("expected %s, got %s", want, conf.downstreamConfig)
+			}
+		})
+	}
+}
+
+func Example_patchUnderPluginKey() {
+	stdin := []byte(`{
+		"type": "gator",
+		"plugin": "tuning",
+		"patchUnderPluginKey": true,
+		"config": {"tuning": {"mtu": 1500, "promisc": false}},
+		"patch": "{\"promisc\": true}"
+	}`)
+	conf, _ := parseConf(stdin)
+	out, _ := formatTestJSON(conf.downstreamConfig)
+	fmt.Println(string(out))
+
+	// Output:
+	// {
+	//   "tuning": {
+	//     "mtu": 1500,
+	//     "promisc": true
+	//   },
+	//   "type": "tuning"
+	// }
+}
+
+func TestPatchUnderPluginKeyRequiresObject(t *testing.T) {
+	stdin := []byte(`{"type": "gator", "plugin": "tuning", "patchUnderPluginKey": true, "patch": "[1, 2]"}`)
+	_, err := parseConf(stdin)
+	if err == nil {
+		t.Fatal("expected error for a patch which is not an object")
+	}
+	if err.Code != ErrInvalidPatchTemplate {
+		t.Fatalf("expected code %d, got %d", ErrInvalidPatchTemplate, err.Code)
+	}
+}
+
+func TestRunExitCodes(t *testing.T) {
+	dir := t.TempDir()
+	writeFakePlugin(t, dir, "failing", `exit 3`)
+	t.Setenv("CNI_PATH", dir)
+
+	tests := []struct {
+		name     string
+		stdin    string
+		exitcode int
+	}{
+		{
+			name:     "template failure",
+			stdin:    `{"type": "gator", "plugin": "failing", "patch": "{{ fail \"oops\" }}"}`,
+			exitcode: ErrInvalidPatchTemplate,
+		},
+		{
+			name:     "plugin not found",
+			stdin:    `{"type": "gator", "plugin": "missing"}`,
+			exitcode: ErrPluginNotFound,
+		},
+		{
+			name:     "delegated failure",
+			stdin:    `{"type": "gator", "plugin": "failing"}`,
+			exitcode: 3,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code := run(nil, bytes.NewBufferString(tt.stdin), &bytes.Buffer{}, &bytes.Buffer{})
+			if code != tt.exitcode {
+				t.Fatalf("expected exit code %d, got %d", tt.exitcode, code)
+			}
+		})
+	}
+}
+
+func TestRunPluginKilledBySignal(t *testing.T) {
+	dir := t.TempDir()
+	writeFakePlugin(t, dir, "killed", `echo '{"cniVersion": "1.0.0", "ips": ['; kill -9 $$`)
+	t.Setenv("CNI_PATH", dir)
+
+	for _, suppress := range []string{"true", "false"} {
+		stdin := `{"type": "gator", "plugin": "killed", "suppressPartialResult": ` + suppress + `}`
+		stdout := &bytes.Buffer{}
+		if code := run(nil, bytes.NewBufferString(stdin), stdout, &bytes.Buffer{}); code != ErrPluginSignaled {
+			t.Fatalf("expected exit code %d, got %d", ErrPluginSignaled, code)
+		}
+		cniErr := types.Error{}
+		if err := json.Unmarshal(stdout.Bytes(), &cniErr); err != nil {
+			t.Fatalf("stdout is not a CNI error: %q", stdout)
+		}
+		if cniErr.Code != ErrPluginSignaled || cniErr.Details != "signal: killed" {
+			t.Fatalf("expected code %d with the signal in details, got %s", ErrPluginSignaled, stdout)
+		}
+	}
+}
+
+// injectPatch replaces the patch in stdin.
+func injectPatch(stdin []byte, patch string) ([]byte, error) {
+	return injectConf(stdin, map[string]interface{}{"patch": patch})
+}
+
+// injectConf sets the top-level keys of fields in stdin.
+func injectConf(stdin []byte, fields map[string]interface{}) ([]byte, error) {
+	conf := map[string]interface{}{}
+	if err := json.Unmarshal(stdin, &conf); err != nil {
+		return nil, err
+	}
+	for k, v := range fields {
+		conf[k] = v
+	}
+	return json.Marshal(conf)
+}
+
+func Example_protectedKeys() {
+	stdin := []byte(`{"cniVersion": "1.0.0", "name": "net", "type": "gator", "plugin": "debug", "patch": "{\"cniVersion\": \"0.3.1\", \"name\": \"other\", \"mtu\": 1400}"}`)
+	conf, _ := parseConf(stdin)
+	fmt.Println(string(conf.downstreamConfig))
+
+	stdin = []byte(`{"cniVersion": "1.0.0", "name": "net", "type": "gator", "plugin": "debug", "protectedKeys": [], "patch": "{\"cniVersion\": \"0.3.1\"}"}`)
+	conf, _ = parseConf(stdin)
+	fmt.Println(string(conf.downstreamConfig))
+
+	// Output:
+	// {"cniVersion":"1.0.0","mtu":1400,"name":"net","type":"debug"}
+	// {"cniVersion":"0.3.1","name":"net","type":"debug"}
+}
+
+// printKeys prints the value of each of the top-level keys in config as JSON.
+func printKeys(config []byte, keys ...string) {
+	obj := map[string]json.RawMessage{}
+	if err := json.Unmarshal(config, &obj); err != nil {
+		fmt.Println(err)
+		return
+	}
+	for _, k := range keys {
+		fmt.Printf("%s: %s\n", k, obj[k])
+	}
+}
+
+func TestRunExitCodeMap(t *testing.T) {
+	dir := t.TempDir()
+	writeFakePlugin(t, dir, "two", `exit 2`)
+	writeFakePlugin(t, dir, "three", `exit 3`)
+	t.Setenv("CNI_PATH", dir)
+
+	stdin := `{"type": "gator", "plugin": "two", "exitCodeMap": {"2": 50}}`
+	if code := run(nil, bytes.NewBufferString(stdin), &bytes.Buffer{}, &bytes.Buffer{}); code != 50 {
+		t.Fatalf("expected mapped exit code 50, got %d", code)
+	}
+
+	stdin = `{"type": "gator", "plugin": "three", "exitCodeMap": {"2": 50}}`
+	if code := run(nil, bytes.NewBufferString(stdin), &bytes.Buffer{}, &bytes.Buffer{}); code != 3 {
+		t.Fatalf("expected unmapped exit code 3, got %d", code)
+	}
+}
+
+func TestRunSkip(t *testing.T) {
+	t.Setenv("CNI_COMMAND", "DEL")
+
+	tests := []struct {
+		name  string
+		stdin string
+		want  string
+	}{
+		{
+			name:  "raw stdin is printed",
+			stdin: `{"type": "gator", "plugin": "debug", "skip": ["DEL"], "patch": "{{ fail \"not executed\" }}"}`,
+			want:  `{"type": "gator", "plugin": "debug", "skip": ["DEL"], "patch": "{{ fail \"not executed\" }}"}`,
+		},
+		{
+			name:  "meta fields are removed with cleanOnSkip",
+			stdin: `{"type": "gator", "plugin": "debug", "skip": ["DEL"], "cleanOnSkip": true, "config": {"a": 1}, "patch": "{{ fail \"not executed\" }}"}`,
+			want:  `{"type":"debug"}`,
+		},
+		{
+			name:  "skip entries are templates",
+			stdin: `{"type": "gator", "plugin": "debug", "external": true, "skip": ["{{ if .external }}DEL{{ end }}"]}`,
+			want:  `{"type": "gator", "plugin": "debug", "external": true, "skip": ["{{ if .external }}DEL{{ end }}"]}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stdout := &bytes.Buffer{}
+			if code := run(nil, bytes.NewBufferString(tt.stdin), stdout, &bytes.Buffer{}); code != 0 {
+				t.Fatalf("expected exit code 0, got %d", code)
+			}
+			if got := stdout.String(); got != tt.want {
+				t.Fatalf("expected %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestParseConfDefaults(t *testing.T) {
+	tests := map[string]struct {
+		stdin string
+		want  string
+	}{
+		"default is applied": {
+			stdin: `{"type": "gator", "plugin": "debug", "defaults": {"mtu": 1450, "ipam": {"type": "host-local"}}, "ipam": {"subnet": "10.0.0.0/24"}}`,
+			want:  `{"ipam":{"subnet":"10.0.0.0/24","type":"host-local"},"mtu":1450,"type":"debug"}`,
+		},
+		"stdin wins": {
+			stdin: `{"type": "gator", "plugin": "debug", "defaults": {"mtu": 1450}, "mtu": 9000}`,
+			want:  `{"mtu":9000,"type":"debug"}`,
+		},
+		"patch wins": {
+			stdin: `{"type": "gator", "plugin": "debug", "defaults": {"mtu": 1450}, "patch": "{\"mtu\": 1500}"}`,
+			want:  `{"mtu":1500,"type":"debug"}`,
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			conf, err := parseConf([]byte(tt.stdin))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := string(conf.downstreamConfig); got != tt.want {
+				t.Fatalf("expected %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestParseConfFinalPatch(t *testing.T) {
+	// The mtu is only known after config has been merged with stdin
+	stdin := []byte(`{
+		"type": "gator",
+		"plugin": "debug",
+		"mtu": 1500,
+		"config": {"overhead": 50},
+		"finalPatch": "{\"mtu\": {{ mtuMinus .Merged.mtu .Merged.overhead }}, \"overhead\": null}"
+	}`)
+	conf, err := parseConf(stdin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(conf.downstreamConfig), `{"mtu":1450,"type":"debug"}`; got != want {
+		t.Fatalf("expected %s, got %s", want, got)
+	}
+}
+
+func TestParseConfRequireEnv(t *testing.T) {
+	t.Setenv("CNI_IFNAME", "eth0")
+	t.Setenv("CNI_NETNS", "")
+	stdin := []byte(`{"type": "gator", "plugin": "debug", "requireEnv": ["CNI_IFNAME", "CNI_NETNS"]}`)
+
+	t.Setenv("CNI_COMMAND", "ADD")
+	_, err := parseConf(stdin)
+	if err == nil || err.Code != types.ErrInvalidEnvironmentVariables || !strings.Contains(err.Msg, "CNI_NETNS") {
+		t.Fatalf("expected an error for the empty CNI_NETNS, got %v", err)
+	}
+
+	t.Setenv("CNI_COMMAND", "DEL")
+	if _, err := parseConf(stdin); err != nil {
+		t.Fatalf("expected DEL to be exempt by default, got %v", err)
+	}
+
+	stdin = []byte(`{"type": "gator", "plugin": "debug", "requireEnv": ["CNI_NETNS"], "requireEnvExempt": []}`)
+	if _, err := parseConf(stdin); err == nil {
+		t.Fatal("expected DEL to be checked when nothing is exempt")
+	}
+}
+
+func TestParseConfSkipTemplate(t *testing.T) {
+	t.Setenv("CNI_COMMAND", "DEL")
+
+	conf, err := parseConf([]byte(`{"type": "gator", "plugin": "debug", "external": false, "skip": ["{{ if .external }}DEL{{ end }}"]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf.skip {
+		t.Fatal("expected an empty skip entry to be ignored")
+	}
+
+	_, err = parseConf([]byte(`{"type": "gator", "plugin": "debug", "skip": ["{{ fail \"no\" }}"]}`))
+	if err == nil || err.Code != ErrInvalidPatchTemplate {
+		t.Fatalf("expected a failing skip template to be fatal, got %v", err)
+	}
+}
+
+func TestRunIdentity(t *testing.T) {
+	dir := t.TempDir()
+	received := filepath.Join(dir, "stdin.json")
+	writeFakePlugin(t, dir, "recorder", `cat > `+received+`; echo '{"cniVersion": "1.0.0"}'`)
+	t.Setenv("CNI_PATH", dir)
+
+	tests := map[string]struct {
+		stdin string
+		env   string
+	}{
+		"identity": {
+			stdin: `{"type": "gator", "plugin": "recorder", "identity": true, "mtu": 1500, "patch": "{{ fail \"not executed\" }}", "resultPatch": "{\"dns\": {}}"}`,
+		},
+		"GATOR_IDENTITY": {
+			stdin: `{"type": "gator", "plugin": "recorder", "mtu": 1500, "patch": "{\"mtu\": 1400}"}`,
+			env:   "true",
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Setenv("GATOR_IDENTITY", tt.env)
+			stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+			if code := run(nil, bytes.NewBufferString(tt.stdin), stdout, stderr); code != 0 {
+				t.Fatalf("expected exit code 0, got %d: %s", code, stderr)
+			}
+			got, err := os.ReadFile(received)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := `{"mtu":1500,"type":"recorder"}`; string(got) != want {
+				t.Fatalf("expected the plugin to receive %s, got %s", want, got)
+			}
+			if want := `{"cniVersion": "1.0.0"}` + "\n"; stdout.String() != want {
+				t.Fatalf("expected the result to be printed as is, got %q", stdout)
+			}
+		})
+	}
+}
+
+func TestRunIdentityDisallowedPlugin(t *testing.T) {
+	dir := t.TempDir()
+	called := filepath.Join(dir, "called")
+	writeFakePlugin(t, dir, "evil", `touch `+called)
+	t.Setenv("CNI_PATH", dir)
+
+	for _, env := range []string{"", "true"} {
+		t.Setenv("GATOR_IDENTITY", env)
+		stdin := `{"type": "gator", "plugin": "evil", "identity": true, "allowedPlugins": ["debug"]}`
+		stdout := &bytes.Buffer{}
+		if code := run(nil, bytes.NewBufferString(stdin), stdout, &bytes.Buffer{}); code != ErrPluginNotAllowed {
+			t.Fatalf("expected exit code %d, got %d: %s", ErrPluginNotAllowed, code, stdout)
+		}
+		if _, err := os.Stat(called); err == nil {
+			t.Fatal("expected the disallowed plugin not to be executed in identity mode")
+		}
+	}
+}
+
+func TestParseConfConfigFile(t *testing.T) {
+	stdin, err := mergePrevResult("testdata/route-override.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdin, _ = injectPatch(stdin, "")
+
+	withFile, _ := jsonpatch.MergePatch(stdin, []byte(`{"configFile": "testdata/config.json"}`))
+	conf, cniErr := parseConf(withFile)
+	if cniErr != nil {
+		t.Fatal(cniErr)
+	}
+	out := map[string]interface{}{}
+	json.Unmarshal(conf.downstreamConfig, &out)
+	if got := fmt.Sprint(out["addroutes"]); got != "[map[dst:10.96.0.0/16 gw:10.244.1.1]]" {
+		t.Fatalf("unexpected addroutes: %s", got)
+	}
+
+	both, _ := jsonpatch.MergePatch(withFile, []byte(`{"config": {}}`))
+	if _, cniErr := parseConf(both); cniErr == nil {
+		t.Fatal("expected error when config and configFile are both set")
+	}
+}
+
+func TestGetPluginPathSymlinks(t *testing.T) {
+	target := writeFakePlugin(t, t.TempDir(), "multicall", `exit 0`)
+
+	dangling, valid := t.TempDir(), t.TempDir()
+	if err := os.Symlink(filepath.Join(dangling, "nowhere"), filepath.Join(dangling, "bridge")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(target, filepath.Join(valid, "bridge")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(dangling, "dir"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("CNI_PATH", dangling+":"+valid)
+
+	got, err := getPluginPath("bridge")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join(valid, "bridge"); got != want {
+		t.Fatalf("expected %s, got %s", want, got)
+	}
+
+	if _, err := getPluginPath("dir"); err == nil {
+		t.Fatal("expected a directory not to be selected")
+	}
+}
+
+func TestRunPrettyDownstream(t *testing.T) {
+	dir := t.TempDir()
+	received := filepath.Join(dir, "stdin.json")
+	writeFakePlugin(t, dir, "recorder", `cat > `+received)
+	t.Setenv("CNI_PATH", dir)
+
+	stdin := `{"type": "gator", "plugin": "recorder", "prettyDownstream": true, "patch": "{\"mtu\": 1400}"}`
+	if code := run(nil, bytes.NewBufferString(stdin), &bytes.Buffer{}, &bytes.Buffer{}); code != 0 {
+		t.Fatalf("expected exit code 0, got %d", code)
+	}
+
+	got, err := os.ReadFile(received)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "{\n  \"mtu\": 1400,\n  \"type\": \"recorder\"\n}"; string(got) != want {
+		t.Fatalf("expected indented config %q, got %q", want, got)
+	}
+}
+
+func Example_networkName() {
+	stdin := []byte(`{"cniVersion": "1.0.0", "name": "pods", "type": "gator", "plugin": "debug", "networkName": "{{ .name }}-debug"}`)
+	conf, err := parseConf(stdin)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Println(string(conf.downstreamConfig))
+
+	stdin = []byte(`{"cniVersion": "1.0.0", "name": "pods", "type": "gator", "plugin": "debug", "networkName": "{{ .missing }}"}`)
+	_, err = parseConf(stdin)
+	fmt.Println(err.Msg)
+
+	// Output:
+	// {"cniVersion":"1.0.0","name":"pods-debug","type":"debug"}
+	// networkName must not be empty
+}
+
+func Example_pluginIndex() {
+	stdin, _ := os.ReadFile("testdata/conflist.json")
+	for _, target := range []string{`{"pluginIndex": 1}`, `{"pluginSelector": {"type": "tuning"}}`} {
+		s, _ := jsonpatch.MergePatch(stdin, []byte(target))
+		conf, err := parseConf(s)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		printKeys(conf.downstreamConfig, "plugins")
+	}
+
+	// Output:
+	// plugins: [{"bridge":"cni0","type":"bridge"},{"mtu":1400,"sysctl":{"net.core.somaxconn":"500"},"type":"tuning"},{"capabilities":{"portMappings":true},"type":"portmap"}]
+	// plugins: [{"bridge":"cni0","type":"bridge"},{"mtu":1400,"sysctl":{"net.core.somaxconn":"500"},"type":"tuning"},{"capabilities":{"portMappings":true},"type":"portmap"}]
+}
+
+func TestPluginSelectorInvalid(t *testing.T) {
+	stdin, err := os.ReadFile("testdata/conflist.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	invalid := []string{
+		`{"pluginIndex": 3}`,
+		`{"pluginSelector": {"type": "macvlan"}}`,
+		`{"pluginSelector": {}}`,
+		`{"pluginIndex": 0, "pluginSelector": {"type": "bridge"}}`,
+	}
+	for _, target := range invalid {
+		s, _ := jsonpatch.MergePatch(stdin, []byte(target))
+		if _, err := parseConf(s); err == nil {
+			t.Errorf("expected error for %s", target)
+		}
+	}
+}
@@ -0,0 +1,321 @@
+package main
+
+import (
+	"fmt"
+	"math/big"
+	"net"
+	"net/netip"
+	"strings"
+)
+
+// cidrSubnet returns the netNum'th subnet of cidr with a prefix length of
+// newPrefixLen. For example, cidrSubnet "10.0.0.0/16" 24 5 is "10.0.5.0/24".
+func cidrSubnet(cidr string, newPrefixLen, netNum interface{}) (string, error) {
+	prefix, err := netip.ParsePrefix(cidr)
+	if err != nil {
+		return "", fmt.Errorf("cidrSubnet: %w", err)
+	}
+	prefix = prefix.Masked()
+
+	newLen, err := toInt(newPrefixLen)
+	if err != nil {
+		return "", fmt.Errorf("cidrSubnet: invalid prefix length: %w", err)
+	}
+	num, err := toInt(netNum)
+	if err != nil {
+		return "", fmt.Errorf("cidrSubnet: invalid subnet number: %w", err)
+	}
+
+	bits := prefix.Addr().BitLen()
+	if newLen <= prefix.Bits() || newLen > bits {
+		return "", fmt.Errorf("cidrSubnet: prefix length %d must be greater than %d and at most %d", newLen, prefix.Bits(), bits)
+	}
+
+	count := new(big.Int).Lsh(big.NewInt(1), uint(newLen-prefix.Bits()))
+	if num < 0 || big.NewInt(int64(num)).Cmp(count) >= 0 {
+		return "", fmt.Errorf("cidrSubnet: subnet number %d out of range for %d subnets of /%d in %s", num, count, newLen, prefix)
+	}
+
+	offset := new(big.Int).Lsh(big.NewInt(int64(num)), uint(bits-newLen))
+	addr, ok := addrAdd(prefix.Addr(), offset)
+	if !ok {
+		return "", fmt.Errorf("cidrSubnet: subnet number %d overflows %s", num, prefix)
+	}
+	return netip.PrefixFrom(addr, newLen).String(), nil
+}
+
+// cidrHost returns the nth address of cidr, counting from the network address,
+// which is the 0th. For example, cidrHost "10.0.0.0/24" 5 is "10.0.0.5".
+func cidrHost(cidr string, n interface{}) (string, error) {
+	prefix, num, err := parseHostArgs(cidr, n)
+	if err != nil {
+		return "", fmt.Errorf("cidrHost: %w", err)
+	}
+	size := prefixSize(prefix)
+	if num.Sign() < 0 || num.Cmp(size) >= 0 {
+		return "", fmt.Errorf("cidrHost: host number %s out of range for %s", num, prefix)
+	}
+	addr, _ := addrAdd(prefix.Addr(), num)
+	return addr.String(), nil
+}
+
+// cidrUsableHost returns the nth usable host address of cidr, counting from 1.
+// Unlike cidrHost, the network address is not usable, and neither is the
+// broadcast address of IPv4. Point-to-point prefixes (/31 and /32 for IPv4, or
+// /127 and /128 for IPv6) have no network or broadcast address, so all of their
+// addresses are usable. For example, cidrUsableHost "10.0.0.0/24" 1 is
+// "10.0.0.1".
+func cidrUsableHost(cidr string, n interface{}) (string, error) {
+	prefix, num, err := parseHostArgs(cidr, n)
+	if err != nil {
+		return "", fmt.Errorf("cidrUsableHost: %w", err)
+	}
+
+	first, usable := usableHosts(prefix)
+	if num.Sign() <= 0 || num.Cmp(usable) > 0 {
+		return "", fmt.Errorf("cidrUsableHost: host number %s out of range for %s usable hosts in %s", num, usable, prefix)
+	}
+	addr, _ := addrAdd(first, num.Sub(num, big.NewInt(1)))
+	return addr.String(), nil
+}
+
+// maxCIDRHosts is the most addresses which cidrHosts returns, so that a large
+// CIDR cannot use a runaway amount of memory.
+const maxCIDRHosts = 65536
+
+// cidrHosts returns the first limit usable host addresses of cidr, in the same
+// order as cidrUsableHost. A limit of 0 returns all of them, which fails if
+// there are more than maxCIDRHosts. For example, cidrHosts "10.0.0.0/24" 2 is
+// ["10.0.0.1", "10.0.0.2"].
+func cidrHosts(cidr string, limit interface{}) ([]string, error) {
+	prefix, n, err := parseHostArgs(cidr, limit)
+	if err != nil {
+		return nil, fmt.Errorf("cidrHosts: %w", err)
+	}
+	if n.Sign() < 0 || n.Cmp(big.NewInt(maxCIDRHosts)) > 0 {
+		return nil, fmt.Errorf("cidrHosts: limit must be between 0 and %d, got %s", maxCIDRHosts, n)
+	}
+
+	addr, usable := usableHosts(prefix)
+	if n.Sign() == 0 {
+		if usable.Cmp(big.NewInt(maxCIDRHosts)) > 0 {
+			return nil, fmt.Errorf("cidrHosts: %s has %s usable hosts, which is more than %d without a limit", prefix, usable, maxCIDRHosts)
+		}
+		n = usable
+	} else if usable.Cmp(n) < 0 {
+		n = usable
+	}
+
+	hosts := make([]string, 0, n.Int64())
+	for i := int64(0); i < n.Int64(); i++ {
+		hosts = append(hosts, addr.String())
+		addr = addr.Next()
+	}
+	return hosts, nil
+}
+
+// usableHosts returns the first usable host address of prefix and the number
+// of usable hosts, as described by cidrUsableHost.
+func usableHosts(prefix netip.Prefix) (netip.Addr, *big.Int) {
+	usable := prefixSize(prefix)
+	if prefix.Addr().BitLen()-prefix.Bits() <= 1 {
+		return prefix.Addr(), usable
+	}
+	if prefix.Addr().Is4() {
+		usable.Sub(usable, big.NewInt(2))
+	} else {
+		usable.Sub(usable, big.NewInt(1))
+	}
+	return prefix.Addr().Next(), usable
+}
+
+// parseHostArgs parses the arguments of cidrHost, cidrUsableHost and
+// cidrHosts.
+func parseHostArgs(cidr string, n interface{}) (netip.Prefix, *big.Int, error) {
+	prefix, err := netip.ParsePrefix(cidr)
+	if err != nil {
+		return netip.Prefix{}, nil, err
+	}
+	num, err := toInt(n)
+	if err != nil {
+		return netip.Prefix{}, nil, fmt.Errorf("invalid host number: %w", err)
+	}
+	return prefix.Masked(), big.NewInt(int64(num)), nil
+}
+
+// prefixSize returns the number of addresses in prefix.
+func prefixSize(prefix netip.Prefix) *big.Int {
+	return new(big.Int).Lsh(big.NewInt(1), uint(prefix.Addr().BitLen()-prefix.Bits()))
+}
+
+// cidrNetwork returns the network address of cidr, which is the address with
+// all of the host bits cleared. For example, cidrNetwork "10.244.1.42/24" is
+// "10.244.1.0".
+func cidrNetwork(cidr string) (string, error) {
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return "", fmt.Errorf("cidrNetwork: %w", err)
+	}
+	return network.IP.String(), nil
+}
+
+// cidrBroadcast returns the broadcast address of the IPv4 cidr, which is the
+// address with all of the host bits set. For example, cidrBroadcast
+// "10.244.1.0/24" is "10.244.1.255". IPv6 has no broadcast addresses, so IPv6
+// CIDRs are rejected.
+func cidrBroadcast(cidr string) (string, error) {
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return "", fmt.Errorf("cidrBroadcast: %w", err)
+	}
+	ip := network.IP.To4()
+	if ip == nil {
+		return "", fmt.Errorf("cidrBroadcast: IPv6 has no broadcast address: %s", cidr)
+	}
+	broadcast := make(net.IP, len(ip))
+	for i := range ip {
+		broadcast[i] = ip[i] | ^network.Mask[i]
+	}
+	return broadcast.String(), nil
+}
+
+// addrAdd returns addr plus offset. It returns false if the result does not
+// fit in the address family of addr.
+func addrAdd(addr netip.Addr, offset *big.Int) (netip.Addr, bool) {
+	sum := new(big.Int).SetBytes(addr.AsSlice())
+	sum.Add(sum, offset)
+	if sum.Sign() < 0 || sum.BitLen() > addr.BitLen() {
+		return netip.Addr{}, false
+	}
+	b := make([]byte, addr.BitLen()/8)
+	sum.FillBytes(b)
+	result, _ := netip.AddrFromSlice(b)
+	return result, true
+}
+
+// parseAddr parses s as either an IP address or a CIDR, and returns the
+// address.
+func parseAddr(s string) (netip.Addr, error) {
+	if addr, err := netip.ParseAddr(s); err == nil {
+		return addr, nil
+	}
+	prefix, err := netip.ParsePrefix(s)
+	if err != nil {
+		return netip.Addr{}, fmt.Errorf("not an IP address or CIDR: %q", s)
+	}
+	return prefix.Addr(), nil
+}
+
+// isIPv4 returns true if addr is an IPv4 address or CIDR.
+func isIPv4(addr string) (bool, error) {
+	a, err := parseAddr(addr)
+	if err != nil {
+		return false, fmt.Errorf("isIPv4: %w", err)
+	}
+	return a.Unmap().Is4(), nil
+}
+
+// isIPv6 returns true if addr is an IPv6 address or CIDR.
+func isIPv6(addr string) (bool, error) {
+	a, err := parseAddr(addr)
+	if err != nil {
+		return false, fmt.Errorf("isIPv6: %w", err)
+	}
+	return !a.Unmap().Is4(), nil
+}
+
+// ptrName returns the reverse-DNS name which a PTR record for ip has, in
+// in-addr.arpa for IPv4 or ip6.arpa for IPv6, without a trailing dot. ip may
+// also be a CIDR, in which case its address is used. For example, ptrName
+// "10.244.1.42" is "42.1.244.10.in-addr.arpa".
+func ptrName(ip string) (string, error) {
+	addr, err := parseAddr(ip)
+	if err != nil {
+		return "", fmt.Errorf("ptrName: %w", err)
+	}
+	addr = addr.Unmap()
+
+	b := addr.AsSlice()
+	labels := []string{}
+	if addr.Is4() {
+		for i := len(b) - 1; i >= 0; i-- {
+			labels = append(labels, fmt.Sprint(b[i]))
+		}
+		return strings.Join(append(labels, "in-addr.arpa"), "."), nil
+	}
+	const hex = "0123456789abcdef"
+	for i := len(b) - 1; i >= 0; i-- {
+		labels = append(labels, string(hex[b[i]&0xf]), string(hex[b[i]>>4]))
+	}
+	return strings.Join(append(labels, "ip6.arpa"), "."), nil
+}
+
+// ipInCIDR returns true if ip is within cidr. ip may also be a CIDR, such as
+// the address of a CNI result, in which case its address is checked. For
+// example, ipInCIDR "10.244.1.42/24" "10.244.0.0/16" is true.
+func ipInCIDR(ip, cidr string) (bool, error) {
+	addr, err := parseAddr(ip)
+	if err != nil {
+		return false, fmt.Errorf("ipInCIDR: %w", err)
+	}
+	prefix, err := netip.ParsePrefix(cidr)
+	if err != nil {
+		return false, fmt.Errorf("ipInCIDR: %w", err)
+	}
+	return prefix.Contains(addr.Unmap()), nil
+}
+
+// byFamily returns the items in ips whose "address" is of the given family (4
+// or 6). The items are objects in the same format as the ips of a CNI result,
+// so the ips of a prevResult can be split by family:
+//
+//	{{ range byFamily 6 .prevResult.ips }}{{ .gateway }}{{ end }}
+func byFamily(family interface{}, ips []interface{}) ([]interface{}, error) {
+	f, err := toInt(family)
+	if err != nil || (f != 4 && f != 6) {
+		return nil, fmt.Errorf("byFamily: family must be 4 or 6, got %v", family)
+	}
+
+	matched := []interface{}{}
+	for _, ip := range ips {
+		obj, ok := ip.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("byFamily: not an object: %v", ip)
+		}
+		address, ok := obj["address"].(string)
+		if !ok {
+			return nil, fmt.Errorf("byFamily: missing address: %v", ip)
+		}
+		v4, err := isIPv4(address)
+		if err != nil {
+			return nil, fmt.Errorf("byFamily: %w", err)
+		}
+		if v4 == (f == 4) {
+			matched = append(matched, ip)
+		}
+	}
+	return matched, nil
+}
+
+// uniqueRoutes returns routes without duplicates, keeping the first of each
+// route with the same dst and gw. For example, to combine the routes from the
+// prevResult with some additional routes:
+//
+//	{{ concat .prevResult.routes $extra | uniqueRoutes | toJson }}
+func uniqueRoutes(routes []interface{}) ([]interface{}, error) {
+	seen := map[[2]interface{}]bool{}
+	unique := []interface{}{}
+	for _, route := range routes {
+		obj, ok := route.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("uniqueRoutes: not an object: %v", route)
+		}
+		key := [2]interface{}{obj["dst"], obj["gw"]}
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		unique = append(unique, route)
+	}
+	return unique, nil
+}
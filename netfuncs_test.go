@@ -0,0 +1,285 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"testing"
+)
+
+func TestCIDRSubnet(t *testing.T) {
+	got, err := cidrSubnet("10.0.0.0/16", 24, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "10.0.5.0/24" {
+		t.Fatalf("expected 10.0.5.0/24, got %s", got)
+	}
+
+	got, err = cidrSubnet("fd00::/48", float64(64), "2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "fd00:0:0:2::/64" {
+		t.Fatalf("expected fd00:0:0:2::/64, got %s", got)
+	}
+
+	invalid := []struct {
+		cidr   string
+		newLen int
+		netNum int
+	}{
+		{"10.0.0.0/16", 16, 0},
+		{"10.0.0.0/16", 33, 0},
+		{"10.0.0.0/16", 24, 256},
+		{"10.0.0.0/16", 24, -1},
+		{"10.0.0.0", 24, 0},
+	}
+	for _, tt := range invalid {
+		if _, err := cidrSubnet(tt.cidr, tt.newLen, tt.netNum); err == nil {
+			t.Errorf("expected error for cidrSubnet %s %d %d", tt.cidr, tt.newLen, tt.netNum)
+		}
+	}
+}
+
+func Example_cidrSubnet() {
+	stdin := []byte(`{"type": "gator", "plugin": "debug", "patch": "{\"subnet\": \"{{cidrSubnet \"10.0.0.0/16\" 24 5}}\"}"}`)
+	conf, _ := parseConf(stdin)
+	out, _ := formatTestJSON(conf.downstreamConfig)
+	fmt.Println(string(out))
+
+	// Output:
+	// {
+	//   "subnet": "10.0.5.0/24",
+	//   "type": "debug"
+	// }
+}
+
+func TestCIDRHost(t *testing.T) {
+	tests := []struct {
+		f    func(string, interface{}) (string, error)
+		cidr string
+		n    int
+		want string
+	}{
+		{cidrHost, "10.0.0.0/24", 0, "10.0.0.0"},
+		{cidrHost, "10.0.0.0/24", 255, "10.0.0.255"},
+		{cidrUsableHost, "10.0.0.0/24", 1, "10.0.0.1"},
+		{cidrUsableHost, "10.0.0.0/24", 10, "10.0.0.10"},
+		{cidrUsableHost, "10.0.0.0/24", 254, "10.0.0.254"},
+		{cidrUsableHost, "10.0.0.0/31", 1, "10.0.0.0"},
+		{cidrUsableHost, "10.0.0.0/31", 2, "10.0.0.1"},
+		{cidrUsableHost, "fd00::/120", 255, "fd00::ff"},
+	}
+	for _, tt := range tests {
+		if got, err := tt.f(tt.cidr, tt.n); err != nil || got != tt.want {
+			t.Errorf("%s %d = %q, %v; want %q", tt.cidr, tt.n, got, err, tt.want)
+		}
+	}
+
+	invalid := []struct {
+		f    func(string, interface{}) (string, error)
+		cidr string
+		n    int
+	}{
+		{cidrHost, "10.0.0.0/24", 256},
+		{cidrHost, "10.0.0.0/24", -1},
+		{cidrUsableHost, "10.0.0.0/24", 0},
+		{cidrUsableHost, "10.0.0.0/24", 255},
+		{cidrUsableHost, "10.0.0.0/31", 3},
+		{cidrUsableHost, "10.0.0.1", 1},
+	}
+	for _, tt := range invalid {
+		if _, err := tt.f(tt.cidr, tt.n); err == nil {
+			t.Errorf("expected error for %s %d", tt.cidr, tt.n)
+		}
+	}
+}
+
+func Example_cidrHosts() {
+	stdin := []byte(`{"type": "gator", "plugin": "debug", "patch": "{\"pool\": {{ cidrHosts \"10.0.0.0/24\" 5 | toJson }}}"}`)
+	conf, err := parseConf(stdin)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	printKeys(conf.downstreamConfig, "pool")
+
+	// Output:
+	// pool: ["10.0.0.1","10.0.0.2","10.0.0.3","10.0.0.4","10.0.0.5"]
+}
+
+func TestCIDRHostsLimit(t *testing.T) {
+	if hosts, err := cidrHosts("10.0.0.0/30", 0); err != nil || len(hosts) != 2 {
+		t.Errorf("expected the 2 usable hosts of a /30, got %v, %v", hosts, err)
+	}
+	if hosts, err := cidrHosts("10.0.0.0/30", 10); err != nil || len(hosts) != 2 {
+		t.Errorf("expected a limit beyond the range to return every host, got %v, %v", hosts, err)
+	}
+	if _, err := cidrHosts("10.0.0.0/8", 0); err == nil {
+		t.Error("expected error for a large CIDR without a limit")
+	}
+	if hosts, err := cidrHosts("fd00::/64", 3); err != nil || len(hosts) != 3 {
+		t.Errorf("expected a large CIDR with a limit to succeed, got %v, %v", hosts, err)
+	}
+	if _, err := cidrHosts("10.0.0.0/24", -1); err == nil {
+		t.Error("expected error for a negative limit")
+	}
+}
+
+func Example_ptrName() {
+	stdin := []byte(`{"type": "gator", "plugin": "debug", "patch": "{\"ptr\": \"{{ ptrName \"10.244.1.42\" }}\", \"ptr6\": \"{{ ptrName \"fd00:10:244:1::2a/64\" }}\"}"}`)
+	conf, err := parseConf(stdin)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	printKeys(conf.downstreamConfig, "ptr", "ptr6")
+
+	// Output:
+	// ptr: "42.1.244.10.in-addr.arpa"
+	// ptr6: "a.2.0.0.0.0.0.0.0.0.0.0.0.0.0.0.1.0.0.0.4.4.2.0.0.1.0.0.0.0.d.f.ip6.arpa"
+}
+
+func TestPtrNameInvalid(t *testing.T) {
+	if _, err := ptrName("host.example"); err == nil {
+		t.Error("expected error for a value which is not an IP address")
+	}
+}
+
+func Example_cidrNetworkBroadcast() {
+	stdin := []byte(`{"type": "gator", "plugin": "debug", "patch": "{\"network\": \"{{ cidrNetwork \"10.244.1.0/24\" }}\", \"broadcast\": \"{{ cidrBroadcast \"10.244.1.0/24\" }}\"}"}`)
+	conf, err := parseConf(stdin)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	printKeys(conf.downstreamConfig, "network", "broadcast")
+
+	// Output:
+	// network: "10.244.1.0"
+	// broadcast: "10.244.1.255"
+}
+
+func TestCIDRBroadcastInvalid(t *testing.T) {
+	for _, cidr := range []string{"10.244.1.1", "not-a-cidr", "fd00::/64"} {
+		if _, err := cidrBroadcast(cidr); err == nil {
+			t.Errorf("expected error for cidrBroadcast %q", cidr)
+		}
+	}
+	if _, err := cidrNetwork("10.244.1.1"); err == nil {
+		t.Error("expected error for cidrNetwork of an address")
+	}
+}
+
+func Example_ipInCIDR() {
+	stdin, _ := mergePrevResult("testdata/route-override.json")
+	patch := `{"pods": {{ with index .prevResult.ips 0 }}{{ ipInCIDR .address "10.244.0.0/16" }}{{ end }}, "services": {{ with index .prevResult.ips 0 }}{{ ipInCIDR .address "10.96.0.0/12" }}{{ end }}}`
+	stdin, _ = injectPatch(stdin, patch)
+	conf, err := parseConf(stdin)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	printKeys(conf.downstreamConfig, "pods", "services")
+
+	stdin, _ = injectPatch(stdin, `{{ $ip := (index .prevResult.ips 0).address }}{{ if ipInCIDR $ip "10.244.0.0/16" }}{"mtu": 1450}{{ else }}{"mtu": 1500}{{ end }}`)
+	if conf, err = parseConf(stdin); err != nil {
+		fmt.Println(err)
+		return
+	}
+	printKeys(conf.downstreamConfig, "mtu")
+
+	// Output:
+	// pods: true
+	// services: false
+	// mtu: 1450
+}
+
+func TestIPInCIDRInvalid(t *testing.T) {
+	if _, err := ipInCIDR("not-an-ip", "10.0.0.0/8"); err == nil {
+		t.Error("expected error for an invalid IP")
+	}
+	if _, err := ipInCIDR("10.0.0.1", "10.0.0.1"); err == nil {
+		t.Error("expected error for an invalid CIDR")
+	}
+}
+
+func TestIsIPFamily(t *testing.T) {
+	tests := []struct {
+		addr string
+		v4   bool
+	}{
+		{"10.244.1.42", true},
+		{"10.244.1.42/24", true},
+		{"::ffff:10.244.1.42", true},
+		{"fd00::1", false},
+		{"fd00::/64", false},
+	}
+	for _, tt := range tests {
+		v4, err := isIPv4(tt.addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		v6, err := isIPv6(tt.addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v4 != tt.v4 || v6 == tt.v4 {
+			t.Errorf("%s: isIPv4 = %v, isIPv6 = %v", tt.addr, v4, v6)
+		}
+	}
+
+	if _, err := isIPv4("not-an-ip"); err == nil {
+		t.Error("expected error for invalid address")
+	}
+	if _, err := byFamily(5, nil); err == nil {
+		t.Error("expected error for invalid family")
+	}
+}
+
+func Example_byFamily() {
+	conf, _ := os.ReadFile("testdata/route-override.json")
+	prevResult, _ := os.ReadFile("testdata/prevresult-dualstack.json")
+	stdin, _ := injectPrevResult(conf, prevResult)
+	stdin, _ = injectPatch(stdin, `{"addroutes": [
+		{{- range byFamily 4 .prevResult.ips }}{"dst": "10.96.0.0/16", "gw": "{{ .gateway }}"},{{ end }}
+		{{- range byFamily 6 .prevResult.ips }}{"dst": "fd00:10:96::/112", "gw": "{{ .gateway }}"}{{ end -}}
+	]}`)
+	c, err := parseConf(stdin)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	out := map[string]interface{}{}
+	json.Unmarshal(c.downstreamConfig, &out)
+	routes, _ := json.MarshalIndent(out["addroutes"], "", "  ")
+	fmt.Println(string(routes))
+
+	// Output:
+	// [
+	//   {
+	//     "dst": "10.96.0.0/16",
+	//     "gw": "10.244.1.1"
+	//   },
+	//   {
+	//     "dst": "fd00:10:96::/112",
+	//     "gw": "fd00:10:244:1::1"
+	//   }
+	// ]
+}
+
+func Example_uniqueRoutes() {
+	stdin, _ := mergePrevResult("testdata/route-override.json")
+	stdin, _ = injectPatch(stdin, `{{ $extra := list (dict "dst" "0.0.0.0/0" "gw" "10.244.1.1") (dict "dst" "10.96.0.0/16" "gw" "10.244.1.1") -}}
+		{"routes": {{ concat .prevResult.routes $extra | uniqueRoutes | toJson }}}`)
+	conf, err := parseConf(stdin)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	printKeys(conf.downstreamConfig, "routes")
+
+	// Output:
+	// routes: [{"dst":"10.244.0.0/16"},{"dst":"0.0.0.0/0","gw":"10.244.1.1"},{"dst":"10.96.0.0/16","gw":"10.244.1.1"}]
+}
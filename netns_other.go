@@ -0,0 +1,10 @@
+//go:build !unix
+
+package main
+
+import "fmt"
+
+// netnsInode returns an error, since inodes are only supported on unix.
+func netnsInode() (string, error) {
+	return "", fmt.Errorf("netnsInode: only supported on unix")
+}
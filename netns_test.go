@@ -0,0 +1,43 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"testing"
+)
+
+func TestNetnsInode(t *testing.T) {
+	netns := filepath.Join(t.TempDir(), "cni-netns")
+	if err := os.WriteFile(netns, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	s, err := os.Stat(netns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := strconv.FormatUint(uint64(s.Sys().(*syscall.Stat_t).Ino), 10)
+
+	t.Setenv("CNI_NETNS", netns)
+	stdin := []byte(`{"type": "gator", "plugin": "debug", "patch": "{\"id\": \"{{ netnsInode }}\"}"}`)
+	conf, cniErr := parseConf(stdin)
+	if cniErr != nil {
+		t.Fatal(cniErr)
+	}
+	if got := string(conf.downstreamConfig); got != `{"id":"`+want+`","type":"debug"}` {
+		t.Fatalf("expected the inode %s, got %s", want, got)
+	}
+
+	t.Setenv("CNI_NETNS", filepath.Join(t.TempDir(), "missing"))
+	if _, err := netnsInode(); err == nil {
+		t.Fatal("expected error for a missing netns")
+	}
+
+	t.Setenv("CNI_NETNS", "")
+	if got, err := netnsInode(); got != "" || err != nil {
+		t.Fatalf("expected an empty inode without CNI_NETNS, got %q, %v", got, err)
+	}
+}
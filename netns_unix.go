@@ -0,0 +1,29 @@
+//go:build unix
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"syscall"
+)
+
+// netnsInode returns the inode number of the network namespace at CNI_NETNS as
+// a string, which is stable for the lifetime of the sandbox. It returns an
+// empty string if CNI_NETNS is not set, such as for some DEL commands.
+func netnsInode() (string, error) {
+	netns := os.Getenv("CNI_NETNS")
+	if netns == "" {
+		return "", nil
+	}
+	s, err := os.Stat(netns)
+	if err != nil {
+		return "", fmt.Errorf("netnsInode: %w", err)
+	}
+	stat, ok := s.Sys().(*syscall.Stat_t)
+	if !ok {
+		return "", fmt.Errorf("netnsInode: no inode for %s", netns)
+	}
+	return strconv.FormatUint(uint64(stat.Ino), 10), nil
+}
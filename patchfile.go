@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/containernetworking/cni/pkg/types"
+)
+
+// gzipMagic are the first bytes of every gzip stream.
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// maxGzipPatchFileBytes is the largest a gzipped patchFile may be once it is
+// decompressed, so that a small file cannot expand to exhaust memory.
+const maxGzipPatchFileBytes = 16 << 20
+
+// readPatchFile returns the patch template in the file at path. Files which
+// start with the gzip magic bytes are decompressed, regardless of their
+// extension, up to [maxGzipPatchFileBytes].
+func readPatchFile(path string) (string, *types.Error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", types.NewError(
+			types.ErrIOFailure,
+			"failed to read patchFile",
+			err.Error(),
+		)
+	}
+
+	if !bytes.HasPrefix(b, gzipMagic) {
+		return string(b), nil
+	}
+
+	r, err := gzip.NewReader(bytes.NewReader(b))
+	if err == nil {
+		b, err = io.ReadAll(io.LimitReader(r, maxGzipPatchFileBytes+1))
+	}
+	if err == nil && len(b) > maxGzipPatchFileBytes {
+		err = fmt.Errorf("decompressed patch is larger than %d bytes", maxGzipPatchFileBytes)
+	}
+	if err != nil {
+		return "", types.NewError(
+			types.ErrIOFailure,
+			"failed to decompress gzipped patchFile",
+			err.Error(),
+		)
+	}
+	return string(b), nil
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/containernetworking/cni/pkg/types"
+)
+
+func TestPatchFileGzip(t *testing.T) {
+	buf := &bytes.Buffer{}
+	w := gzip.NewWriter(buf)
+	if _, err := w.Write([]byte(`{"mtu": {{ mtuMinus 1500 50 }}}`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// No .gz extension, so that the magic bytes are what is detected
+	path := filepath.Join(t.TempDir(), "patch.tmpl")
+	if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	stdin := []byte(`{"type": "gator", "plugin": "debug", "patchFile": "` + path + `"}`)
+	conf, err := parseConf(stdin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"mtu":1450,"type":"debug"}`; string(conf.downstreamConfig) != want {
+		t.Fatalf("expected %s, got %s", want, conf.downstreamConfig)
+	}
+}
+
+func TestPatchFileCorruptGzip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "patch.json.gz")
+	if err := os.WriteFile(path, append([]byte{0x1f, 0x8b}, "not gzip"...), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	_, err := readPatchFile(path)
+	if err == nil || err.Code != types.ErrIOFailure {
+		t.Fatalf("expected code %d, got %v", types.ErrIOFailure, err)
+	}
+}
+
+func TestPatchFileGzipTooLarge(t *testing.T) {
+	buf := &bytes.Buffer{}
+	w := gzip.NewWriter(buf)
+	if _, err := w.Write(bytes.Repeat([]byte(" "), maxGzipPatchFileBytes+1)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "patch.json.gz")
+	if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	_, err := readPatchFile(path)
+	if err == nil || err.Code != types.ErrIOFailure || !strings.Contains(err.Details, "larger than") {
+		t.Fatalf("expected a size error, got %v", err)
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+)
+
+// isExecutable returns true if path is a regular file which is executable by
+// someone. Stat follows symlinks, so a dangling symlink is not executable.
+// Callers use the symlink itself rather than its target, since multi-call
+// binaries depend on the name they are called with.
+func isExecutable(path string) bool {
+	s, err := os.Stat(path)
+	return err == nil && s.Mode().IsRegular() && s.Mode()&0111 != 0
+}
+
+// readPathCache returns the plugin paths in the GATOR_PATH_CACHE file, which
+// are keyed by the plugin name and CNI_PATH. A cache which is missing or
+// cannot be parsed is empty.
+func readPathCache(file string) map[string]string {
+	cache := map[string]string{}
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return cache
+	}
+	if err := json.Unmarshal(b, &cache); err != nil {
+		logger.Warn("ignoring invalid GATOR_PATH_CACHE", "file", file, "error", err)
+		return map[string]string{}
+	}
+	return cache
+}
+
+// writePathCache sets key to path in the GATOR_PATH_CACHE file. The file is
+// replaced by renaming a temporary file, so concurrent invocations never see
+// a partial cache, although one of two concurrent updates may be lost. This is
+// best-effort: failures are logged and otherwise ignored.
+func writePathCache(file, key, path string) {
+	cache := readPathCache(file)
+	cache[key] = path
+	b, _ := json.Marshal(cache)
+
+	tmp, err := os.CreateTemp(filepath.Dir(file), filepath.Base(file)+".*")
+	if err != nil {
+		logger.Warn("failed to write GATOR_PATH_CACHE", "file", file, "error", err)
+		return
+	}
+	_, err = tmp.Write(b)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), file)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		logger.Warn("failed to write GATOR_PATH_CACHE", "file", file, "error", err)
+	}
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestGetPluginPathCache(t *testing.T) {
+	first, second := t.TempDir(), t.TempDir()
+	t.Setenv("CNI_PATH", first+":"+second)
+	t.Setenv("GATOR_PATH_CACHE", filepath.Join(t.TempDir(), "paths.json"))
+
+	cached := writeFakePlugin(t, second, "cached", `exit 0`)
+	if got, err := getPluginPath("cached"); err != nil || got != cached {
+		t.Fatalf("expected %s, got %s, %v", cached, got, err)
+	}
+
+	// A plugin earlier in CNI_PATH would normally win, but the cached path is
+	// still used while it exists.
+	shadow := writeFakePlugin(t, first, "cached", `exit 0`)
+	if got, err := getPluginPath("cached"); err != nil || got != cached {
+		t.Fatalf("expected the cached %s, got %s, %v", cached, got, err)
+	}
+
+	if err := os.Remove(cached); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := getPluginPath("cached"); err != nil || got != shadow {
+		t.Fatalf("expected the cache to be invalidated for %s, got %s, %v", shadow, got, err)
+	}
+}
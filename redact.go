@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"slices"
+	"strings"
+
+	"github.com/containernetworking/cni/pkg/types"
+)
+
+// redacted replaces the values of [PluginConfig.RedactKeys].
+const redacted = "***"
+
+// secrets returns the values in stdin of the keys in
+// [PluginConfig.RedactKeys], at any depth. Values which are not strings are
+// returned as JSON.
+func (conf *PluginConfig) secrets() []string {
+	if conf == nil || len(conf.RedactKeys) == 0 {
+		return nil
+	}
+	var data interface{}
+	if err := json.Unmarshal(conf.stdin, &data); err != nil {
+		return nil
+	}
+
+	secrets := []string{}
+	var walk func(v interface{})
+	walk = func(v interface{}) {
+		switch v := v.(type) {
+		case map[string]interface{}:
+			for k, child := range v {
+				if !slices.Contains(conf.RedactKeys, k) {
+					walk(child)
+					continue
+				}
+				if s, ok := child.(string); ok {
+					secrets = append(secrets, s)
+				} else if b, err := json.Marshal(child); err == nil {
+					secrets = append(secrets, string(b))
+				}
+			}
+		case []interface{}:
+			for _, child := range v {
+				walk(child)
+			}
+		}
+	}
+	walk(data)
+	return secrets
+}
+
+// redactor returns a function which masks the values of
+// [PluginConfig.RedactKeys] in a string.
+func (conf *PluginConfig) redactor() func(string) string {
+	replacements := []string{}
+	for _, s := range conf.secrets() {
+		if s != "" {
+			replacements = append(replacements, s, redacted)
+		}
+	}
+	if len(replacements) == 0 {
+		return func(s string) string { return s }
+	}
+	return strings.NewReplacer(replacements...).Replace
+}
+
+// redactJSON returns a copy of the JSON document doc with the values of
+// [PluginConfig.RedactKeys] replaced at any depth, and any secrets which were
+// copied into other strings masked.
+func (conf *PluginConfig) redactJSON(doc []byte) ([]byte, error) {
+	if len(conf.RedactKeys) == 0 {
+		return doc, nil
+	}
+	var data interface{}
+	if err := json.Unmarshal(doc, &data); err != nil {
+		return nil, err
+	}
+
+	redact := conf.redactor()
+	var walk func(v interface{}) interface{}
+	walk = func(v interface{}) interface{} {
+		switch v := v.(type) {
+		case map[string]interface{}:
+			for k, child := range v {
+				if slices.Contains(conf.RedactKeys, k) {
+					v[k] = redacted
+				} else {
+					v[k] = walk(child)
+				}
+			}
+		case []interface{}:
+			for i, child := range v {
+				v[i] = walk(child)
+			}
+		case string:
+			return redact(v)
+		}
+		return v
+	}
+	return json.Marshal(walk(data))
+}
+
+// redactError returns a copy of err with the values of
+// [PluginConfig.RedactKeys] masked.
+func (conf *PluginConfig) redactError(err *types.Error) *types.Error {
+	redact := conf.redactor()
+	return types.NewError(err.Code, redact(err.Msg), redact(err.Details))
+}
+
+// redactHandler is a [slog.Handler] which masks secrets in the message and
+// string attributes of each record.
+type redactHandler struct {
+	slog.Handler
+	redact func(string) string
+}
+
+func (h redactHandler) Handle(ctx context.Context, r slog.Record) error {
+	redactedRecord := slog.NewRecord(r.Time, r.Level, h.redact(r.Message), r.PC)
+	r.Attrs(func(a slog.Attr) bool {
+		redactedRecord.AddAttrs(h.redactAttr(a))
+		return true
+	})
+	return h.Handler.Handle(ctx, redactedRecord)
+}
+
+func (h redactHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	redactedAttrs := make([]slog.Attr, len(attrs))
+	for i, a := range attrs {
+		redactedAttrs[i] = h.redactAttr(a)
+	}
+	return redactHandler{h.Handler.WithAttrs(redactedAttrs), h.redact}
+}
+
+func (h redactHandler) WithGroup(name string) slog.Handler {
+	return redactHandler{h.Handler.WithGroup(name), h.redact}
+}
+
+func (h redactHandler) redactAttr(a slog.Attr) slog.Attr {
+	switch a.Value.Kind() {
+	case slog.KindString:
+		return slog.String(a.Key, h.redact(a.Value.String()))
+	case slog.KindGroup:
+		attrs := []any{}
+		for _, child := range a.Value.Group() {
+			attrs = append(attrs, h.redactAttr(child))
+		}
+		return slog.Group(a.Key, attrs...)
+	default:
+		return a
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestRunRedactsErrors(t *testing.T) {
+	stdin := `{"type": "gator", "plugin": "debug", "redactKeys": ["token"], "ipam": {"token": "s3cr3t"}, "patch": "{{ fail (printf \"bad token %s\" .ipam.token) }}"}`
+	stdout := &bytes.Buffer{}
+	if code := run(nil, bytes.NewBufferString(stdin), stdout, &bytes.Buffer{}); code != ErrInvalidPatchTemplate {
+		t.Fatalf("expected exit code %d, got %d", ErrInvalidPatchTemplate, code)
+	}
+	if strings.Contains(stdout.String(), "s3cr3t") {
+		t.Fatalf("error contains the redacted value: %s", stdout)
+	}
+	if !strings.Contains(stdout.String(), "bad token ***") {
+		t.Fatalf("expected the value to be masked, got %s", stdout)
+	}
+}
+
+func TestRunRedactsLogs(t *testing.T) {
+	logs := captureLogs(t)
+	dir := t.TempDir()
+	writeFakePlugin(t, dir, "failing", `echo "rejected token s3cr3t" >&2; exit 1`)
+	t.Setenv("CNI_PATH", dir)
+
+	stdin := `{"type": "gator", "plugin": "failing", "redactKeys": ["token"], "token": "s3cr3t"}`
+	run(nil, bytes.NewBufferString(stdin), &bytes.Buffer{}, &bytes.Buffer{})
+	if strings.Contains(logs.String(), "s3cr3t") {
+		t.Fatalf("log contains the redacted value: %s", logs)
+	}
+	if !strings.Contains(logs.String(), "rejected token ***") {
+		t.Fatalf("expected the value to be masked, got %s", logs)
+	}
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+
+	"github.com/containernetworking/cni/pkg/types"
+	jsonpatch "github.com/evanphx/json-patch"
+)
+
+// captureResult writes result to a new file in the directory named by
+// GATOR_RESULT_OUT, if it is set. This is best-effort: failures are logged and
+// otherwise ignored.
+func captureResult(plugin, command string, result []byte) {
+	dir := os.Getenv("GATOR_RESULT_OUT")
+	if dir == "" {
+		return
+	}
+
+	f, err := os.CreateTemp(dir, plugin+"-"+command+"-*.json")
+	if err != nil {
+		logger.Warn("failed to capture result", "error", err)
+		return
+	}
+	defer f.Close()
+
+	if _, err := f.Write(result); err != nil {
+		logger.Warn("failed to capture result", "file", f.Name(), "error", err)
+	}
+}
+
+// openResultFD returns the file descriptor named by GATOR_RESULT_FD, which the
+// result is also written to, or nil if it is not set. It fails if the value is
+// not a file descriptor which is open for writing, or is one of stdin, stdout
+// and stderr, so that a misconfigured supervisor is noticed before the plugin
+// is called.
+func openResultFD() (*os.File, *types.Error) {
+	v := os.Getenv("GATOR_RESULT_FD")
+	if v == "" {
+		return nil, nil
+	}
+	fd, err := strconv.Atoi(v)
+	if err == nil && fd < 0 {
+		err = strconv.ErrRange
+	}
+	// The result would corrupt stdout, or be closed along with stdin or stderr
+	if err == nil && fd < 3 {
+		return nil, types.NewError(
+			types.ErrInvalidEnvironmentVariables,
+			"GATOR_RESULT_FD cannot be stdin, stdout or stderr",
+			fmt.Sprintf("GATOR_RESULT_FD=%d, must be 3 or more", fd),
+		)
+	}
+	if err == nil {
+		err = fdWritable(fd)
+	}
+	if err != nil {
+		return nil, types.NewError(
+			types.ErrInvalidEnvironmentVariables,
+			"GATOR_RESULT_FD must be a file descriptor which is open for writing",
+			err.Error(),
+		)
+	}
+	return os.NewFile(uintptr(fd), "GATOR_RESULT_FD"), nil
+}
+
+// writeResultFD writes result to f and closes it, if f is not nil. This is
+// best-effort, since stdout is the result: failures are logged and otherwise
+// ignored.
+func writeResultFD(f *os.File, result []byte) {
+	if f == nil {
+		return
+	}
+	defer f.Close()
+	if _, err := f.Write(result); err != nil {
+		logger.Warn("failed to write result to GATOR_RESULT_FD", "error", err)
+	}
+}
+
+// patchResult applies [PluginConfig.ResultPatch] and the entry in
+// [PluginConfig.CommandResultPatches] for the CNI_COMMAND to result. If there
+// are no result patches, or the plugin did not print a result (which is common
+// for DEL), result is returned unchanged.
+func patchResult(conf *PluginConfig, result []byte) ([]byte, *types.Error) {
+	if conf.ResultPatch == "" && len(conf.CommandResultPatches) == 0 {
+		return result, nil
+	}
+	if len(bytes.TrimSpace(result)) == 0 {
+		return result, nil
+	}
+
+	data := templateData(conf)
+	var parsed interface{}
+	if jsonErr := json.Unmarshal(result, &parsed); jsonErr != nil {
+		return nil, types.NewError(
+			types.ErrDecodingFailure,
+			"failed to parse the result of the downstream plugin",
+			jsonErr.Error(),
+		)
+	}
+	data["Result"] = parsed
+
+	opts := conf.funcOptions()
+	patch, err := executeTemplate("conf.ResultPatch", conf.ResultPatch, data, opts)
+	if err != nil {
+		return nil, err
+	}
+	if len(patch) == 0 {
+		patch = []byte("{}")
+	}
+	patch, err = layerCommandPatch("conf.CommandResultPatches", conf.CommandResultPatches, patch, data, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	patched, mergeErr := jsonpatch.MergePatch(result, patch)
+	if mergeErr != nil {
+		return nil, types.NewError(
+			ErrMergeJSONFailed,
+			"failed to merge resultPatch with the result",
+			mergeErr.Error(),
+		)
+	}
+	return patched, nil
+}
+
+// copyResultKeys copies the [PluginConfig.ResultKeys] from the downstream
+// config into result, replacing their values in result. If the plugin did not
+// print a result, result is returned unchanged.
+func copyResultKeys(conf *PluginConfig, result []byte) ([]byte, *types.Error) {
+	if len(conf.ResultKeys) == 0 || len(bytes.TrimSpace(result)) == 0 {
+		return result, nil
+	}
+
+	config := map[string]json.RawMessage{}
+	if err := json.Unmarshal(conf.downstreamConfig, &config); err != nil {
+		return nil, types.NewError(ErrMergeJSONFailed, "failed to copy resultKeys", err.Error())
+	}
+	copied := map[string]json.RawMessage{}
+	if err := json.Unmarshal(result, &copied); err != nil {
+		return nil, types.NewError(
+			types.ErrDecodingFailure,
+			"failed to parse the result of the downstream plugin",
+			err.Error(),
+		)
+	}
+
+	for _, k := range conf.ResultKeys {
+		if v, ok := config[k]; ok {
+			copied[k] = v
+		}
+	}
+
+	out, err := json.Marshal(copied)
+	if err != nil {
+		return nil, types.NewError(ErrMergeJSONFailed, "failed to copy resultKeys", err.Error())
+	}
+	return out, nil
+}
+
+// mergeConfigIntoResult adds the [PluginConfig.MergeConfigIntoResult] keys of
+// the downstream config to result. Keys which result already has are not
+// changed. prevResult is never added, since it is the result of the previous
+// plugin, and neither are [PluginConfig.RedactKeys], since the result is
+// returned to the runtime. If the plugin did not print a result, result is
+// returned unchanged.
+func mergeConfigIntoResult(conf *PluginConfig, result []byte) ([]byte, *types.Error) {
+	if len(conf.MergeConfigIntoResult) == 0 || len(bytes.TrimSpace(result)) == 0 {
+		return result, nil
+	}
+
+	config := map[string]json.RawMessage{}
+	if err := json.Unmarshal(conf.downstreamConfig, &config); err != nil {
+		return nil, types.NewError(ErrMergeJSONFailed, "failed to merge the downstream config into the result", err.Error())
+	}
+	merged := map[string]json.RawMessage{}
+	if err := json.Unmarshal(result, &merged); err != nil {
+		return nil, types.NewError(
+			types.ErrDecodingFailure,
+			"failed to parse the result of the downstream plugin",
+			err.Error(),
+		)
+	}
+
+	for _, k := range conf.MergeConfigIntoResult {
+		if k == "prevResult" || slices.Contains(conf.RedactKeys, k) {
+			continue
+		}
+		v, ok := config[k]
+		if _, exists := merged[k]; ok && !exists {
+			merged[k] = v
+		}
+	}
+
+	out, err := json.Marshal(merged)
+	if err != nil {
+		return nil, types.NewError(ErrMergeJSONFailed, "failed to merge the downstream config into the result", err.Error())
+	}
+	return out, nil
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRunCaptureResult(t *testing.T) {
+	dir := t.TempDir()
+	writeFakePlugin(t, dir, "ok", `echo '{"cniVersion": "1.0.0"}'`)
+	out := t.TempDir()
+	t.Setenv("CNI_PATH", dir)
+	t.Setenv("CNI_COMMAND", "ADD")
+	t.Setenv("GATOR_RESULT_OUT", out)
+
+	stdout := &bytes.Buffer{}
+	if code := run(nil, bytes.NewBufferString(`{"type": "gator", "plugin": "ok"}`), stdout, &bytes.Buffer{}); code != 0 {
+		t.Fatalf("expected exit code 0, got %d", code)
+	}
+
+	files, err := filepath.Glob(filepath.Join(out, "ok-ADD-*.json"))
+	if err != nil || len(files) != 1 {
+		t.Fatalf("expected one captured result, got %v (%v)", files, err)
+	}
+	captured, err := os.ReadFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(captured, stdout.Bytes()) {
+		t.Fatalf("captured result %q does not match stdout %q", captured, stdout)
+	}
+}
+
+func TestRunCaptureResultBestEffort(t *testing.T) {
+	dir := t.TempDir()
+	writeFakePlugin(t, dir, "ok", `echo '{}'`)
+	t.Setenv("CNI_PATH", dir)
+	t.Setenv("GATOR_RESULT_OUT", filepath.Join(dir, "missing"))
+	captureLogs(t)
+
+	if code := run(nil, bytes.NewBufferString(`{"type": "gator", "plugin": "ok"}`), &bytes.Buffer{}, &bytes.Buffer{}); code != 0 {
+		t.Fatalf("expected exit code 0, got %d", code)
+	}
+}
+
+func TestRunResultPatchOnDel(t *testing.T) {
+	dir := t.TempDir()
+	writeFakePlugin(t, dir, "ok", `echo '{"cniVersion": "1.0.0", "interfaces": [{"name": "eth0"}]}'`)
+	writeFakePlugin(t, dir, "quiet", `exit 0`)
+	t.Setenv("CNI_PATH", dir)
+	t.Setenv("CNI_COMMAND", "DEL")
+
+	stdin := `{
+		"type": "gator",
+		"plugin": "ok",
+		"resultPatch": "{\"cniVersion\": \"{{ .Result.cniVersion }}\", \"dns\": {}}",
+		"commandResultPatches": {
+			"ADD": "{\"interfaces\": []}",
+			"DEL": "{\"interfaces\": null}"
+		}
+	}`
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	if code := run(nil, bytes.NewBufferString(stdin), stdout, stderr); code != 0 {
+		t.Fatalf("expected exit code 0, got %d: %s", code, stderr)
+	}
+	if want := `{"cniVersion":"1.0.0","dns":{}}`; stdout.String() != want {
+		t.Fatalf("expected patched result %s, got %s", want, stdout)
+	}
+
+	// A DEL which prints nothing is left alone
+	stdout, stderr = &bytes.Buffer{}, &bytes.Buffer{}
+	if code := run(nil, bytes.NewBufferString(`{"type": "gator", "plugin": "quiet", "resultPatch": "{\"dns\": {}}"}`), stdout, stderr); code != 0 {
+		t.Fatalf("expected exit code 0, got %d: %s", code, stderr)
+	}
+	if stdout.Len() != 0 {
+		t.Fatalf("expected no result, got %s", stdout)
+	}
+}
+
+func TestRunResultKeys(t *testing.T) {
+	dir := t.TempDir()
+	writeFakePlugin(t, dir, "ok", `echo '{"cniVersion": "1.0.0", "routes": [{"dst": "0.0.0.0/0"}], "dns": {}}'`)
+	t.Setenv("CNI_PATH", dir)
+	t.Setenv("CNI_COMMAND", "ADD")
+
+	stdin := `{
+		"type": "gator",
+		"plugin": "ok",
+		"resultKeys": ["routes", "missing"],
+		"patch": "{\"routes\": [{\"dst\": \"10.96.0.0/16\", \"gw\": \"10.244.1.1\"}]}"
+	}`
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	if code := run(nil, bytes.NewBufferString(stdin), stdout, stderr); code != 0 {
+		t.Fatalf("expected exit code 0, got %d: %s", code, stderr)
+	}
+	if want := `{"cniVersion":"1.0.0","dns":{},"routes":[{"dst":"10.96.0.0/16","gw":"10.244.1.1"}]}`; stdout.String() != want {
+		t.Fatalf("expected result %s, got %s", want, stdout)
+	}
+}
+
+func TestRunMergeConfigIntoResult(t *testing.T) {
+	dir := t.TempDir()
+	writeFakePlugin(t, dir, "ok", `echo '{"cniVersion": "1.0.0", "dns": {"nameservers": ["10.0.0.1"]}, "routes": []}'`)
+	t.Setenv("CNI_PATH", dir)
+	t.Setenv("CNI_COMMAND", "ADD")
+
+	stdin := `{
+		"type": "gator",
+		"plugin": "ok",
+		"cniVersion": "0.4.0",
+		"mergeConfigIntoResult": ["mtu", "dns", "routes", "prevResult", "token", "missing"],
+		"resultKeys": ["routes"],
+		"redactKeys": ["token"],
+		"token": "s3cret",
+		"ipam": {"type": "host-local"},
+		"prevResult": {"cniVersion": "0.4.0"},
+		"patch": "{\"mtu\": 1400, \"dns\": {}, \"routes\": [{\"dst\": \"10.96.0.0/16\"}]}"
+	}`
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	if code := run(nil, bytes.NewBufferString(stdin), stdout, stderr); code != 0 {
+		t.Fatalf("expected exit code 0, got %d: %s", code, stderr)
+	}
+	want := `{"cniVersion":"1.0.0","dns":{"nameservers":["10.0.0.1"]},"mtu":1400,"routes":[{"dst":"10.96.0.0/16"}]}`
+	if stdout.String() != want {
+		t.Fatalf("expected result %s, got %s", want, stdout)
+	}
+}
@@ -0,0 +1,20 @@
+//go:build linux || darwin || freebsd
+
+package main
+
+import (
+	"errors"
+	"syscall"
+)
+
+// fdWritable returns an error if fd is not open, or is open read-only.
+func fdWritable(fd int) error {
+	flags, _, errno := syscall.Syscall(syscall.SYS_FCNTL, uintptr(fd), syscall.F_GETFL, 0)
+	if errno != 0 {
+		return errno
+	}
+	if flags&syscall.O_ACCMODE == syscall.O_RDONLY {
+		return errors.New("file descriptor is read-only")
+	}
+	return nil
+}
@@ -0,0 +1,11 @@
+//go:build !(linux || darwin || freebsd)
+
+package main
+
+import "errors"
+
+// fdWritable returns an error, since GATOR_RESULT_FD is only supported on
+// Linux, macOS and FreeBSD.
+func fdWritable(fd int) error {
+	return errors.New("not supported on this platform")
+}
@@ -0,0 +1,72 @@
+//go:build linux || darwin || freebsd
+
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"strconv"
+	"syscall"
+	"testing"
+
+	"github.com/containernetworking/cni/pkg/types"
+)
+
+func TestRunResultFD(t *testing.T) {
+	dir := t.TempDir()
+	writeFakePlugin(t, dir, "ok", `echo '{"cniVersion": "1.0.0"}'`)
+	t.Setenv("CNI_PATH", dir)
+	t.Setenv("CNI_COMMAND", "ADD")
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	// gator closes the fd after writing to it, so give it a copy
+	fd, err := syscall.Dup(int(w.Fd()))
+	w.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("GATOR_RESULT_FD", strconv.Itoa(fd))
+
+	stdout := &bytes.Buffer{}
+	if code := run(nil, bytes.NewBufferString(`{"type": "gator", "plugin": "ok"}`), stdout, &bytes.Buffer{}); code != 0 {
+		t.Fatalf("expected exit code 0, got %d: %s", code, stdout)
+	}
+	delivered, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(delivered, stdout.Bytes()) {
+		t.Fatalf("result on the fd %q does not match stdout %q", delivered, stdout)
+	}
+
+	// The read end of the pipe is not writable
+	t.Setenv("GATOR_RESULT_FD", strconv.Itoa(int(r.Fd())))
+	stdout.Reset()
+	if code := run(nil, bytes.NewBufferString(`{"type": "gator", "plugin": "ok"}`), stdout, &bytes.Buffer{}); code != int(types.ErrInvalidEnvironmentVariables) {
+		t.Fatalf("expected exit code %d for a read-only fd, got %d", types.ErrInvalidEnvironmentVariables, code)
+	}
+	if bytes.Contains(stdout.Bytes(), []byte(`"cniVersion"`)) {
+		t.Fatalf("expected the plugin not to be called, got %s", stdout)
+	}
+
+	for _, fd := range []string{"0", "1", "2"} {
+		t.Setenv("GATOR_RESULT_FD", fd)
+		stdout.Reset()
+		if code := run(nil, bytes.NewBufferString(`{"type": "gator", "plugin": "ok"}`), stdout, &bytes.Buffer{}); code != int(types.ErrInvalidEnvironmentVariables) {
+			t.Fatalf("expected exit code %d for fd %s, got %d", types.ErrInvalidEnvironmentVariables, fd, code)
+		}
+		if !bytes.Contains(stdout.Bytes(), []byte("cannot be stdin, stdout or stderr")) {
+			t.Fatalf("expected fd %s to be rejected, got %s", fd, stdout)
+		}
+	}
+
+	t.Setenv("GATOR_RESULT_FD", "stdout")
+	if code := run(nil, bytes.NewBufferString(`{"type": "gator", "plugin": "ok"}`), &bytes.Buffer{}, &bytes.Buffer{}); code != int(types.ErrInvalidEnvironmentVariables) {
+		t.Fatalf("expected exit code %d for an invalid fd, got %d", types.ErrInvalidEnvironmentVariables, code)
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"fmt"
+	"net/netip"
+)
+
+// prevResult is the prevResult from the template data as a plain interface.
+type prevResult map[string]interface{}
+
+// prevResultOf returns the prevResult from the template data. It is empty if
+// there is no prevResult.
+func prevResultOf(data interface{}) prevResult {
+	if m, ok := data.(map[string]interface{}); ok {
+		if r, ok := m["prevResult"].(map[string]interface{}); ok {
+			return r
+		}
+	}
+	return prevResult{}
+}
+
+// list returns the items of the list at key, skipping any which are not
+// objects.
+func (r prevResult) list(key string) []map[string]interface{} {
+	items, _ := r[key].([]interface{})
+	objs := []map[string]interface{}{}
+	for _, item := range items {
+		if obj, ok := item.(map[string]interface{}); ok {
+			objs = append(objs, obj)
+		}
+	}
+	return objs
+}
+
+// defaultGateway returns the gateway of the default route for the given
+// family (4 or 6) in the prevResult. If there is no such route, it returns
+// the optional fallback, or an error if no fallback is given.
+func (r prevResult) defaultGateway(family interface{}, fallback ...string) (string, error) {
+	f, err := toInt(family)
+	if err != nil || (f != 4 && f != 6) {
+		return "", fmt.Errorf("defaultGateway: family must be 4 or 6, got %v", family)
+	}
+	dst := "0.0.0.0/0"
+	if f == 6 {
+		dst = "::/0"
+	}
+
+	for _, route := range r.list("routes") {
+		if route["dst"] != dst {
+			continue
+		}
+		if gw, ok := route["gw"].(string); ok && gw != "" {
+			return gw, nil
+		}
+	}
+
+	if len(fallback) > 0 {
+		return fallback[0], nil
+	}
+	return "", fmt.Errorf("defaultGateway: no IPv%d default route with a gateway in prevResult", f)
+}
+
+// routeFor returns the route in the prevResult for dst, which is an IP address
+// or CIDR. It is the most specific route whose dst contains all of dst, so
+// that "0.0.0.0/0" finds the default route and "10.244.3.0/24" may find a
+// route to "10.244.0.0/16". It returns an error if no route matches.
+func (r prevResult) routeFor(dst string) (map[string]interface{}, error) {
+	target, err := netip.ParsePrefix(dst)
+	if err != nil {
+		addr, addrErr := netip.ParseAddr(dst)
+		if addrErr != nil {
+			return nil, fmt.Errorf("routeFor: not an IP address or CIDR: %q", dst)
+		}
+		target = netip.PrefixFrom(addr, addr.BitLen())
+	}
+	target = target.Masked()
+
+	var best map[string]interface{}
+	bestBits := -1
+	for _, route := range r.list("routes") {
+		s, _ := route["dst"].(string)
+		prefix, err := netip.ParsePrefix(s)
+		if err != nil {
+			continue
+		}
+		if prefix.Bits() <= target.Bits() && prefix.Contains(target.Addr()) && prefix.Bits() > bestBits {
+			best, bestBits = route, prefix.Bits()
+		}
+	}
+	if best == nil {
+		return nil, fmt.Errorf("routeFor: no route in prevResult for %s", dst)
+	}
+	return best, nil
+}
+
+// sandboxInterface returns the first interface in the prevResult which has a
+// sandbox, which is the interface inside the container. It returns an empty
+// object if there is none.
+func (r prevResult) sandboxInterface() map[string]interface{} {
+	for _, iface := range r.list("interfaces") {
+		if sandbox, ok := iface["sandbox"].(string); ok && sandbox != "" {
+			return iface
+		}
+	}
+	return map[string]interface{}{}
+}
+
+// sandboxIfname returns the name of [prevResult.sandboxInterface], or an empty
+// string if there is none.
+func (r prevResult) sandboxIfname() string {
+	name, _ := r.sandboxInterface()["name"].(string)
+	return name
+}
+
+// sandboxMAC returns the MAC address of [prevResult.sandboxInterface], or an
+// empty string if there is none.
+func (r prevResult) sandboxMAC() string {
+	mac, _ := r.sandboxInterface()["mac"].(string)
+	return mac
+}
+
+// ipCount returns the number of ips in the prevResult, or 0 if there is none.
+func (r prevResult) ipCount() int {
+	return len(r.list("ips"))
+}
+
+// interfaceCount returns the number of interfaces in the prevResult, or 0 if
+// there is none.
+func (r prevResult) interfaceCount() int {
+	return len(r.list("interfaces"))
+}
+
+// isDualStack returns true if the prevResult has both an IPv4 and an IPv6
+// address. Addresses which cannot be parsed are ignored.
+func (r prevResult) isDualStack() bool {
+	var has4, has6 bool
+	for _, ip := range r.list("ips") {
+		address, _ := ip["address"].(string)
+		a, err := parseAddr(address)
+		if err != nil {
+			continue
+		}
+		if a.Unmap().Is4() {
+			has4 = true
+		} else {
+			has6 = true
+		}
+	}
+	return has4 && has6
+}
+
+// prevResultsOf returns the result of each prior plugin for .PrevResults. The
+// results are taken from the prevResults list in stdin, where each item has
+// the plugin and its result, if it is present. Otherwise, the merged
+// prevResult is the only result, with an empty plugin. It is an empty list if
+// there is neither.
+func prevResultsOf(data map[string]interface{}) []interface{} {
+	if list, ok := data["prevResults"].([]interface{}); ok {
+		results := []interface{}{}
+		for _, item := range list {
+			if obj, ok := item.(map[string]interface{}); ok {
+				results = append(results, map[string]interface{}{
+					"plugin": obj["plugin"],
+					"result": obj["result"],
+				})
+			}
+		}
+		return results
+	}
+	if r, ok := data["prevResult"].(map[string]interface{}); ok {
+		return []interface{}{map[string]interface{}{"plugin": "", "result": r}}
+	}
+	return []interface{}{}
+}
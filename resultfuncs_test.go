@@ -0,0 +1,172 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"testing"
+)
+
+func Example_defaultGateway() {
+	stdin, _ := mergePrevResult("testdata/route-override.json")
+	stdin, _ = injectPatch(stdin, `{"gw": "{{ defaultGateway 4 }}", "gw6": "{{ defaultGateway 6 "" }}"}`)
+	conf, err := parseConf(stdin)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	printKeys(conf.downstreamConfig, "gw", "gw6")
+
+	// Output:
+	// gw: "10.244.1.1"
+	// gw6: ""
+}
+
+func TestDefaultGatewayMissing(t *testing.T) {
+	if _, err := prevResultOf(nil).defaultGateway(4); err == nil {
+		t.Fatal("expected error when there is no prevResult")
+	}
+	if _, err := prevResultOf(nil).defaultGateway("7"); err == nil {
+		t.Fatal("expected error for an invalid family")
+	}
+}
+
+func Example_routeFor() {
+	stdin, _ := mergePrevResult("testdata/route-override.json")
+	stdin, _ = injectPatch(stdin, `{
+		"default": {{ routeFor "0.0.0.0/0" | toJson }},
+		"pods": {{ routeFor "10.244.3.0/24" | toJson }},
+		"host": {{ routeFor "10.244.1.5" | toJson }}
+	}`)
+	conf, err := parseConf(stdin)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	printKeys(conf.downstreamConfig, "default", "pods", "host")
+
+	// Output:
+	// default: {"dst":"0.0.0.0/0","gw":"10.244.1.1"}
+	// pods: {"dst":"10.244.0.0/16"}
+	// host: {"dst":"10.244.0.0/16"}
+}
+
+func TestRouteForMissing(t *testing.T) {
+	if _, err := prevResultOf(nil).routeFor("0.0.0.0/0"); err == nil {
+		t.Fatal("expected error when there is no prevResult")
+	}
+	r := prevResultOf(map[string]interface{}{
+		"prevResult": map[string]interface{}{
+			"routes": []interface{}{map[string]interface{}{"dst": "10.244.0.0/16"}},
+		},
+	})
+	if _, err := r.routeFor("10.0.0.0/8"); err == nil {
+		t.Fatal("expected error when only a more specific route exists")
+	}
+	if _, err := r.routeFor("gateway"); err == nil {
+		t.Fatal("expected error for an invalid destination")
+	}
+}
+
+func Example_sandboxInterface() {
+	stdin, _ := mergePrevResult("testdata/route-override.json")
+	stdin, _ = injectPatch(stdin, `{"iface": {{ sandboxInterface | toJson }}, "ifname": "{{ sandboxIfname }}", "mac": "{{ sandboxMAC }}"}`)
+	conf, err := parseConf(stdin)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	printKeys(conf.downstreamConfig, "iface", "ifname", "mac")
+
+	// Output:
+	// iface: {"mac":"00:00:00:00:00:03","name":"eth0","sandbox":"/var/run/netns/cni-00000000-1111-2222-3333-444444444444"}
+	// ifname: "eth0"
+	// mac: "00:00:00:00:00:03"
+}
+
+func TestSandboxInterfaceMissing(t *testing.T) {
+	r := prevResultOf(map[string]interface{}{
+		"prevResult": map[string]interface{}{
+			"interfaces": []interface{}{map[string]interface{}{"name": "cni0"}},
+		},
+	})
+	if iface := r.sandboxInterface(); len(iface) != 0 {
+		t.Fatalf("expected no sandbox interface, got %v", iface)
+	}
+	if r.sandboxIfname() != "" || r.sandboxMAC() != "" {
+		t.Fatal("expected empty name and MAC")
+	}
+}
+
+func Example_isDualStack() {
+	conf, _ := os.ReadFile("testdata/route-override.json")
+	prevResult, _ := os.ReadFile("testdata/prevresult-dualstack.json")
+	stdin, _ := injectPrevResult(conf, prevResult)
+	stdin, _ = injectPatch(stdin, `{
+		"stack": "{{ if isDualStack }}dual{{ else }}single{{ end }}",
+		"ips": {{ ipCount }},
+		"interfaces": {{ interfaceCount }}
+	}`)
+	c, err := parseConf(stdin)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	printKeys(c.downstreamConfig, "stack", "ips", "interfaces")
+
+	// Output:
+	// stack: "dual"
+	// ips: 2
+	// interfaces: 1
+}
+
+func TestPrevResultCountsMissing(t *testing.T) {
+	r := prevResultOf(nil)
+	if r.ipCount() != 0 || r.interfaceCount() != 0 || r.isDualStack() {
+		t.Fatal("expected zero counts and not dual-stack without a prevResult")
+	}
+
+	r = prevResultOf(map[string]interface{}{
+		"prevResult": map[string]interface{}{
+			"ips": []interface{}{map[string]interface{}{"address": "10.244.1.42/24"}},
+		},
+	})
+	if r.ipCount() != 1 || r.isDualStack() {
+		t.Fatal("expected one ip which is not dual-stack")
+	}
+}
+
+func Example_prevResults() {
+	stdin := []byte(`{
+		"type": "gator",
+		"plugin": "debug",
+		"prevResults": [
+			{"plugin": "bridge", "result": {"interfaces": [{"name": "cni0"}]}},
+			{"plugin": "macvlan", "result": {"interfaces": [{"name": "eth1", "sandbox": "/var/run/netns/test"}]}}
+		],
+		"patch": "{\"ifnames\": [{{ range $i, $r := .PrevResults }}{{ if $i }}, {{ end }}\"{{ $r.plugin }}:{{ (index $r.result.interfaces 0).name }}\"{{ end }}]}"
+	}`)
+	conf, err := parseConf(stdin)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	printKeys(conf.downstreamConfig, "ifnames")
+
+	// Output:
+	// ifnames: ["bridge:cni0","macvlan:eth1"]
+}
+
+func TestPrevResultsFallback(t *testing.T) {
+	merged := map[string]interface{}{"cniVersion": "1.0.0"}
+	results := prevResultsOf(map[string]interface{}{"prevResult": merged})
+	if len(results) != 1 {
+		t.Fatalf("expected the merged prevResult as the only result, got %v", results)
+	}
+	if r := results[0].(map[string]interface{}); r["plugin"] != "" || r["result"].(map[string]interface{})["cniVersion"] != "1.0.0" {
+		t.Fatalf("expected the merged prevResult with no plugin, got %v", r)
+	}
+
+	if results := prevResultsOf(map[string]interface{}{}); len(results) != 0 {
+		t.Fatalf("expected no results without a prevResult, got %v", results)
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/containernetworking/cni/pkg/types"
+	jsonpatch "github.com/evanphx/json-patch"
+)
+
+// renderJSONPatch executes the [PluginConfig.JSONPatch] template with data,
+// and decodes the result as an RFC6902 JSON patch.
+func renderJSONPatch(text string, data interface{}, opts funcOptions) (jsonpatch.Patch, *types.Error) {
+	rendered, err := executeTemplate("conf.JSONPatch", text, data, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	ops := []map[string]interface{}{}
+	if err := json.Unmarshal(rendered, &ops); err != nil {
+		return nil, types.NewError(
+			ErrInvalidPatchTemplate,
+			"jsonPatch must render to a JSON array of operations",
+			err.Error(),
+		)
+	}
+	for i, op := range ops {
+		if _, ok := op["op"].(string); !ok {
+			return nil, types.NewError(
+				ErrInvalidPatchTemplate,
+				"jsonPatch must render to a JSON array of operations",
+				fmt.Sprintf("operation %d has no op: %v", i, op),
+			)
+		}
+	}
+
+	patch, decodeErr := jsonpatch.DecodePatch(rendered)
+	if decodeErr != nil {
+		return nil, types.NewError(
+			ErrInvalidPatchTemplate,
+			"failed to decode jsonPatch",
+			decodeErr.Error(),
+		)
+	}
+	return patch, nil
+}
+
+// envPatch returns a JSON patch which adds the value of each environment
+// variable in envMap at its JSON pointer. Environment variables which are not
+// set are skipped, with a warning.
+func envPatch(envMap map[string]string, warn func(string)) (jsonpatch.Patch, *types.Error) {
+	pointers := make([]string, 0, len(envMap))
+	for pointer := range envMap {
+		pointers = append(pointers, pointer)
+	}
+	sort.Strings(pointers)
+
+	ops := []map[string]string{}
+	for _, pointer := range pointers {
+		if !strings.HasPrefix(pointer, "/") {
+			return nil, types.NewError(
+				types.ErrInvalidNetworkConfig,
+				"envMap keys must be JSON pointers",
+				pointer,
+			)
+		}
+		value, ok := os.LookupEnv(envMap[pointer])
+		if !ok {
+			warn(fmt.Sprintf("envMap: %s is not set, so %s was not changed", envMap[pointer], pointer))
+			continue
+		}
+		ops = append(ops, map[string]string{"op": "add", "path": pointer, "value": value})
+	}
+
+	b, _ := json.Marshal(ops)
+	patch, err := jsonpatch.DecodePatch(b)
+	if err != nil {
+		return nil, types.NewError(
+			types.ErrInvalidNetworkConfig,
+			"failed to decode envMap",
+			err.Error(),
+		)
+	}
+	return patch, nil
+}
+
+// EnvField is an entry of [PluginConfig.EnvToConfig], which sets the field of
+// the downstream config at the JSON pointer Pointer to the value of the
+// environment variable EnvVar, or to Default if EnvVar is not set.
+type EnvField struct {
+	Pointer string
+	EnvVar  string
+	Default string
+}
+
+// envToConfigPatch returns a JSON patch which adds the value of each of fields
+// at its JSON pointer, in order. Fields whose environment variable is not set
+// and which have no default are skipped, with a warning.
+func envToConfigPatch(fields []EnvField, warn func(string)) (jsonpatch.Patch, *types.Error) {
+	ops := []map[string]string{}
+	for i, field := range fields {
+		if !strings.HasPrefix(field.Pointer, "/") {
+			return nil, types.NewError(
+				types.ErrInvalidNetworkConfig,
+				fmt.Sprintf("envToConfig[%d].pointer must be a JSON pointer", i),
+				field.Pointer,
+			)
+		}
+		if field.EnvVar == "" {
+			return nil, types.NewError(
+				types.ErrInvalidNetworkConfig,
+				fmt.Sprintf("envToConfig[%d].envVar must not be empty", i),
+				field.Pointer,
+			)
+		}
+		value, ok := os.LookupEnv(field.EnvVar)
+		if !ok {
+			if field.Default == "" {
+				warn(fmt.Sprintf("envToConfig: %s is not set and has no default, so %s was not changed", field.EnvVar, field.Pointer))
+				continue
+			}
+			value = field.Default
+		}
+		ops = append(ops, map[string]string{"op": "add", "path": field.Pointer, "value": value})
+	}
+
+	b, _ := json.Marshal(ops)
+	patch, err := jsonpatch.DecodePatch(b)
+	if err != nil {
+		return nil, types.NewError(
+			types.ErrInvalidNetworkConfig,
+			"failed to decode envToConfig",
+			err.Error(),
+		)
+	}
+	return patch, nil
+}
+
+// applyJSONPatch applies patch to config. The operations are applied one at a
+// time, so that an error names the index, op and path of the operation which
+// failed, such as a replace of a path which does not exist. name is the name
+// of the patch, for errors.
+func applyJSONPatch(name string, patch jsonpatch.Patch, config []byte) ([]byte, *types.Error) {
+	for i, op := range patch {
+		patched, err := jsonpatch.Patch{op}.Apply(config)
+		if err != nil {
+			path, _ := op.Path()
+			return nil, types.NewError(
+				ErrMergeJSONFailed,
+				fmt.Sprintf("failed to apply %s operation %d: %s %s", name, i, op.Kind(), path),
+				err.Error(),
+			)
+		}
+		config = patched
+	}
+	return config, nil
+}
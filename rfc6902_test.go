@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/containernetworking/cni/pkg/types"
+)
+
+func Example_jsonPatch() {
+	stdin, _ := mergePrevResult("testdata/route-override.json")
+	stdin, _ = injectConf(stdin, map[string]interface{}{
+		"patch": "",
+		"jsonPatch": `[
+			{{- range $i, $r := .prevResult.routes }}
+			{{- if $i }},{{ end }}{"op": "remove", "path": "/prevResult/routes/0"}
+			{{- end -}}
+		]`,
+	})
+	conf, err := parseConf(stdin)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	out := struct {
+		PrevResult struct {
+			Routes []interface{} `json:"routes"`
+		} `json:"prevResult"`
+	}{}
+	if err := json.Unmarshal(conf.downstreamConfig, &out); err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Println(len(out.PrevResult.Routes))
+
+	// Output:
+	// 0
+}
+
+func TestJSONPatchMustBeArray(t *testing.T) {
+	stdin := []byte(`{"type": "gator", "plugin": "debug", "jsonPatch": "{\"op\": \"remove\", \"path\": \"/type\"}"}`)
+	_, err := parseConf(stdin)
+	if err == nil || err.Code != ErrInvalidPatchTemplate {
+		t.Fatalf("expected code %d, got %v", ErrInvalidPatchTemplate, err)
+	}
+
+	stdin = []byte(`{"type": "gator", "plugin": "debug", "jsonPatch": "[{\"path\": \"/type\"}]"}`)
+	_, err = parseConf(stdin)
+	if err == nil || err.Code != ErrInvalidPatchTemplate {
+		t.Fatalf("expected code %d, got %v", ErrInvalidPatchTemplate, err)
+	}
+}
+
+func TestEnvMap(t *testing.T) {
+	t.Setenv("GATOR_TEST_CLUSTER", "prod")
+	t.Setenv("GATOR_TEST_REGION", "eu-west-1")
+	stdin := []byte(`{
+		"type": "gator",
+		"plugin": "debug",
+		"patch": "{\"labels\": {\"region\": \"unknown\"}}",
+		"envMap": {
+			"/cluster": "GATOR_TEST_CLUSTER",
+			"/labels/region": "GATOR_TEST_REGION",
+			"/zone": "GATOR_TEST_UNSET"
+		}
+	}`)
+	conf, err := parseConf(stdin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"cluster":"prod","labels":{"region":"eu-west-1"},"type":"debug"}`; string(conf.downstreamConfig) != want {
+		t.Fatalf("expected %s, got %s", want, conf.downstreamConfig)
+	}
+
+	stdin = []byte(`{"type": "gator", "plugin": "debug", "envMap": {"cluster": "GATOR_TEST_CLUSTER"}}`)
+	if _, err := parseConf(stdin); err == nil || err.Code != types.ErrInvalidNetworkConfig {
+		t.Fatalf("expected code %d, got %v", types.ErrInvalidNetworkConfig, err)
+	}
+}
+
+func TestEnvToConfig(t *testing.T) {
+	t.Setenv("CNI_CONTAINERID", "abc123")
+	stdin := []byte(`{
+		"type": "gator",
+		"plugin": "debug",
+		"patch": "{\"labels\": {}}",
+		"envToConfig": [
+			{"pointer": "/labels/containerID", "envVar": "CNI_CONTAINERID"},
+			{"pointer": "/cluster", "envVar": "GATOR_TEST_UNSET", "default": "dev"},
+			{"pointer": "/zone", "envVar": "GATOR_TEST_UNSET"}
+		]
+	}`)
+	conf, err := parseConf(stdin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"cluster":"dev","labels":{"containerID":"abc123"},"type":"debug"}`; string(conf.downstreamConfig) != want {
+		t.Fatalf("expected %s, got %s", want, conf.downstreamConfig)
+	}
+
+	for _, field := range []string{
+		`{"pointer": "containerID", "envVar": "CNI_CONTAINERID"}`,
+		`{"pointer": "/containerID"}`,
+	} {
+		stdin = []byte(`{"type": "gator", "plugin": "debug", "envToConfig": [` + field + `]}`)
+		if _, err := parseConf(stdin); err == nil || err.Code != types.ErrInvalidNetworkConfig {
+			t.Errorf("%s: expected code %d, got %v", field, types.ErrInvalidNetworkConfig, err)
+		}
+	}
+}
+
+func TestJSONPatchNamesFailedOp(t *testing.T) {
+	stdin := []byte(`{"type": "gator", "plugin": "debug", "mtu": 1500, "jsonPatch": "[{\"op\": \"replace\", \"path\": \"/mtu\", \"value\": 1400}, {\"op\": \"replace\", \"path\": \"/missing/key\", \"value\": 1}]"}`)
+	_, err := parseConf(stdin)
+	if err == nil || err.Code != ErrMergeJSONFailed {
+		t.Fatalf("expected code %d, got %v", ErrMergeJSONFailed, err)
+	}
+	if want := "failed to apply jsonPatch operation 1: replace /missing/key"; err.Msg != want {
+		t.Fatalf("expected %q, got %q", want, err.Msg)
+	}
+}
@@ -0,0 +1,19 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+// runAsID returns the uid or gid in id, or def if it is nil. name is the name
+// of the field, for errors.
+func runAsID(name string, id *int, def int) (uint32, error) {
+	if id == nil {
+		return uint32(def), nil
+	}
+	// The largest ID is reserved as an error value by setuid and setgid
+	if *id < 0 || *id >= math.MaxUint32 {
+		return 0, fmt.Errorf("%s must be between 0 and %d, got %d", name, math.MaxUint32-1, *id)
+	}
+	return uint32(*id), nil
+}
@@ -0,0 +1,17 @@
+//go:build !unix
+
+package main
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// sysProcAttr returns an error if [PluginConfig.RunAsUser] or
+// [PluginConfig.RunAsGroup] is set, since they are only supported on unix.
+func (conf *PluginConfig) sysProcAttr() (*syscall.SysProcAttr, error) {
+	if conf.RunAsUser == nil && conf.RunAsGroup == nil {
+		return nil, nil
+	}
+	return nil, fmt.Errorf("runAsUser and runAsGroup are only supported on unix")
+}
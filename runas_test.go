@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/containernetworking/cni/pkg/types"
+)
+
+func TestRunAsUser(t *testing.T) {
+	dir := t.TempDir()
+	// The plugin must be reachable by the user it is run as
+	for _, d := range []string{filepath.Dir(dir), dir} {
+		if err := os.Chmod(d, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeFakePlugin(t, dir, "ids", `echo "{\"uid\": $(id -u), \"gid\": $(id -g)}"`)
+	t.Setenv("CNI_PATH", dir)
+
+	if os.Geteuid() != 0 {
+		// Without privileges, gator cannot change to root
+		stdin := `{"type": "gator", "plugin": "ids", "runAsUser": 0, "runAsGroup": 0}`
+		stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+		if code := run(nil, bytes.NewBufferString(stdin), stdout, stderr); code != ErrExecFailed {
+			t.Fatalf("expected exit code %d, got %d: %s", ErrExecFailed, code, stderr)
+		}
+		if output := stdout.String() + stderr.String(); !strings.Contains(output, "runAsUser") {
+			t.Fatalf("expected a permission error naming runAsUser, got: %s", output)
+		}
+		return
+	}
+
+	stdin := `{"type": "gator", "plugin": "ids", "runAsUser": 65534, "runAsGroup": 65534}`
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	code := run(nil, bytes.NewBufferString(stdin), stdout, stderr)
+	if code != 0 {
+		t.Fatalf("expected exit code 0, got %d: %s", code, stderr)
+	}
+	if want := `{"uid": 65534, "gid": 65534}` + "\n"; stdout.String() != want {
+		t.Fatalf("expected the plugin to run as %s, got %s", want, stdout)
+	}
+}
+
+func TestRunAsUserInvalid(t *testing.T) {
+	dir := t.TempDir()
+	writeFakePlugin(t, dir, "ok", `exit 0`)
+	t.Setenv("CNI_PATH", dir)
+
+	stdin := `{"type": "gator", "plugin": "ok", "runAsUser": -1}`
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	if code := run(nil, bytes.NewBufferString(stdin), stdout, stderr); code != int(types.ErrInvalidNetworkConfig) {
+		t.Fatalf("expected exit code %d, got %d: %s", types.ErrInvalidNetworkConfig, code, stderr)
+	}
+}
@@ -0,0 +1,34 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// sysProcAttr returns the attributes which the downstream plugin is started
+// with, to run it as [PluginConfig.RunAsUser] and [PluginConfig.RunAsGroup].
+// If only one of them is set, the other is gator's own. It returns nil if
+// neither is set.
+func (conf *PluginConfig) sysProcAttr() (*syscall.SysProcAttr, error) {
+	if conf.RunAsUser == nil && conf.RunAsGroup == nil {
+		return nil, nil
+	}
+	uid, err := runAsID("runAsUser", conf.RunAsUser, os.Geteuid())
+	if err != nil {
+		return nil, err
+	}
+	gid, err := runAsID("runAsGroup", conf.RunAsGroup, os.Getegid())
+	if err != nil {
+		return nil, err
+	}
+	return &syscall.SysProcAttr{
+		Credential: &syscall.Credential{
+			Uid: uid,
+			Gid: gid,
+			// Only root can clear the supplementary groups
+			NoSetGroups: os.Geteuid() != 0,
+		},
+	}, nil
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/containernetworking/cni/pkg/types"
+	"github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+// validateSchema validates config against the JSON schema in schemaFile. If
+// the config is invalid, the returned error lists every violation.
+func validateSchema(schemaFile string, config []byte) *types.Error {
+	schema, err := jsonschema.Compile(schemaFile)
+	if err != nil {
+		return types.NewError(
+			types.ErrInvalidNetworkConfig,
+			fmt.Sprintf("failed to load JSON schema: %s", schemaFile),
+			err.Error(),
+		)
+	}
+
+	d := json.NewDecoder(bytes.NewReader(config))
+	d.UseNumber()
+	var v interface{}
+	if err := d.Decode(&v); err != nil {
+		return types.NewError(
+			types.ErrDecodingFailure,
+			"failed to parse downstream config for schema validation",
+			err.Error(),
+		)
+	}
+
+	if err := schema.Validate(v); err != nil {
+		details := err.Error()
+		var ve *jsonschema.ValidationError
+		if errors.As(err, &ve) {
+			details = strings.Join(schemaViolations(ve), "; ")
+		}
+		return types.NewError(
+			ErrSchemaValidation,
+			fmt.Sprintf("downstream config does not match JSON schema: %s", schemaFile),
+			details,
+		)
+	}
+
+	return nil
+}
+
+// schemaViolations returns a description of each leaf error in ve.
+func schemaViolations(ve *jsonschema.ValidationError) []string {
+	if len(ve.Causes) == 0 {
+		location := ve.InstanceLocation
+		if location == "" {
+			location = "/"
+		}
+		return []string{fmt.Sprintf("%s: %s", location, ve.Message)}
+	}
+	violations := []string{}
+	for _, cause := range ve.Causes {
+		violations = append(violations, schemaViolations(cause)...)
+	}
+	return violations
+}
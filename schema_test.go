@@ -0,0 +1,25 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseConfSchemaFile(t *testing.T) {
+	stdin := []byte(`{"type": "gator", "plugin": "debug", "schemaFile": "testdata/schema.json", "patch": "{\"cniOutput\": \"/tmp/out.log\"}"}`)
+	if _, err := parseConf(stdin); err != nil {
+		t.Fatalf("expected valid config, got %v", err)
+	}
+
+	stdin = []byte(`{"type": "gator", "plugin": "debug", "schemaFile": "testdata/schema.json", "patch": "{\"cniOutptu\": \"/tmp/out.log\"}"}`)
+	_, err := parseConf(stdin)
+	if err == nil {
+		t.Fatal("expected schema validation to fail")
+	}
+	if err.Code != ErrSchemaValidation {
+		t.Fatalf("expected code %d, got %d", ErrSchemaValidation, err.Code)
+	}
+	if !strings.Contains(err.Details, "cniOutput") {
+		t.Fatalf("expected details to name the missing property, got %q", err.Details)
+	}
+}
@@ -0,0 +1,7 @@
+// funcplugin-nosymbol is a function plugin for the funcPlugins tests which
+// does not export Funcs.
+package main
+
+func Shout(s string) string {
+	return s
+}
@@ -0,0 +1,16 @@
+// funcplugin is a function plugin for the funcPlugins tests, which is built
+// with go build -buildmode=plugin.
+package main
+
+import (
+	"strings"
+	"text/template"
+)
+
+type funcs struct{}
+
+func (funcs) FuncMap() template.FuncMap {
+	return template.FuncMap{"shout": strings.ToUpper}
+}
+
+var Funcs funcs
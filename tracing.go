@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"os"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// tracer creates the spans for each stage of an invocation. It is a no-op
+// unless tracing is configured by [setupTracing].
+var tracer trace.Tracer = noop.NewTracerProvider().Tracer("gator")
+
+// setupTracing configures [tracer] to export spans over OTLP if
+// OTEL_EXPORTER_OTLP_ENDPOINT is set, and to record them in timings if it is
+// not nil. The rest of the exporter configuration is read from the standard
+// OTEL_* environment variables. The returned function flushes any pending
+// spans, restores the previous tracer and must be called before exiting.
+func setupTracing(ctx context.Context, timings *stageTimings) (shutdown func(context.Context) error, err error) {
+	opts := []sdktrace.TracerProviderOption{}
+	if os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") != "" {
+		exporter, err := otlptracehttp.New(ctx)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, sdktrace.WithBatcher(exporter))
+	}
+	if timings != nil {
+		opts = append(opts, sdktrace.WithSpanProcessor(timings))
+	}
+	if len(opts) == 0 {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	provider := sdktrace.NewTracerProvider(opts...)
+	orig := tracer
+	tracer = provider.Tracer("gator")
+	return func(ctx context.Context) error {
+		tracer = orig
+		return provider.Shutdown(ctx)
+	}, nil
+}
+
+// Attributes which are added to the span for the whole invocation.
+var (
+	attrPlugin     = attribute.Key("cni.plugin")
+	attrCNICommand = attribute.Key("cni.command")
+)
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bytes"
+	"slices"
+	"testing"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestRunSpans(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	orig := tracer
+	tracer = sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)).Tracer("gator")
+	t.Cleanup(func() { tracer = orig })
+
+	dir := t.TempDir()
+	writeFakePlugin(t, dir, "ok", `echo '{}'`)
+	t.Setenv("CNI_PATH", dir)
+	t.Setenv("CNI_COMMAND", "ADD")
+
+	stdin := bytes.NewBufferString(`{"type": "gator", "plugin": "ok", "patch": "{\"a\": \"{{.type}}\"}"}`)
+	if code := run(nil, stdin, &bytes.Buffer{}, &bytes.Buffer{}); code != 0 {
+		t.Fatalf("expected exit code 0, got %d", code)
+	}
+
+	spans := recorder.Ended()
+	names := []string{}
+	for _, s := range spans {
+		names = append(names, s.Name())
+	}
+	for _, name := range []string{"gator", "parse", "template", "merge", "delegate"} {
+		if !slices.Contains(names, name) {
+			t.Errorf("expected span %s, got %v", name, names)
+		}
+	}
+
+	root := spans[len(spans)-1]
+	if root.Name() != "gator" {
+		t.Fatalf("expected root span to end last, got %s", root.Name())
+	}
+	attrs := map[string]string{}
+	for _, a := range root.Attributes() {
+		attrs[string(a.Key)] = a.Value.AsString()
+	}
+	if attrs["cni.plugin"] != "ok" || attrs["cni.command"] != "ADD" {
+		t.Errorf("unexpected root span attributes: %v", attrs)
+	}
+	for _, s := range spans[:len(spans)-1] {
+		if s.Parent().SpanID() != root.SpanContext().SpanID() {
+			t.Errorf("expected span %s to be a child of the root span", s.Name())
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/containernetworking/cni/pkg/types"
+)
+
+// Validate returns an error if conf sets fields which cannot be set together,
+// which are the [exclusiveProperties], or sets a field without the fields
+// which it requires, which are the [dependentProperties]. Apart from the
+// durations, which it parses and stores so that an invalid one fails before
+// any hook runs, it only checks the combination of fields, not their values.
+func (conf *PluginConfig) Validate() *types.Error {
+	for _, props := range exclusiveProperties {
+		set := []string{}
+		for _, p := range props {
+			if conf.isSet(p) {
+				set = append(set, p)
+			}
+		}
+		if len(set) > 1 {
+			return types.NewError(
+				types.ErrInvalidNetworkConfig,
+				fmt.Sprintf("%s cannot be set together", strings.Join(set, " and ")),
+				"",
+			)
+		}
+	}
+
+	props := make([]string, 0, len(dependentProperties))
+	for prop := range dependentProperties {
+		props = append(props, prop)
+	}
+	sort.Strings(props)
+	for _, prop := range props {
+		if !conf.isSet(prop) {
+			continue
+		}
+		for _, required := range dependentProperties[prop] {
+			if !conf.isSet(required) {
+				return types.NewError(
+					types.ErrInvalidNetworkConfig,
+					fmt.Sprintf("%s requires %s to be set", prop, required),
+					"",
+				)
+			}
+		}
+	}
+
+	var err *types.Error
+	if conf.slowThreshold, err = parseDuration("slowThreshold", conf.SlowThreshold); err != nil {
+		return err
+	}
+	if conf.patchURLTimeout, err = parseDuration("patchURLTimeout", conf.PatchURLTimeout); err != nil {
+		return err
+	}
+	return conf.parseTimeouts()
+}
+
+// isSet returns true if the exported field of conf for the property is not its
+// zero value. Like JSON, properties match fields case-insensitively.
+func (conf *PluginConfig) isSet(prop string) bool {
+	v := reflect.ValueOf(conf).Elem()
+	for _, field := range reflect.VisibleFields(v.Type()) {
+		if field.IsExported() && strings.EqualFold(field.Name, prop) {
+			return !v.FieldByIndex(field.Index).IsZero()
+		}
+	}
+	return false
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/containernetworking/cni/pkg/types"
+)
+
+func TestValidate(t *testing.T) {
+	invalid := map[string]string{
+		`{"patch": "{}", "patchFile": "patch.json"}`:                                   "patch and patchFile",
+		`{"patch": "{}", "patchURL": "https://example.com/patch"}`:                     "patch and patchURL",
+		`{"config": {}, "configFile": "config.json"}`:                                  "config and configFile",
+		`{"pluginIndex": 0, "pluginSelector": {"type": "bridge"}}`:                     "pluginIndex and pluginSelector",
+		`{"cleanOnSkip": true}`:                                                        "cleanOnSkip requires skip",
+		`{"downstreamLogLevelEnv": "LOG_LEVEL"}`:                                       "downstreamLogLevelEnv requires downstreamLogLevel",
+		`{"requireEnvExempt": ["DEL"]}`:                                                "requireEnvExempt requires requireEnv",
+		`{"postExecFailure": "abort", "skip": ["DEL"], "patch": "{}"}`:                 "postExecFailure requires postExec",
+		`{"cleanOnSkip": true, "skip": ["DEL"], "pluginByCapability": "portMappings"}`: "cleanOnSkip and pluginByCapability",
+		`{"patchURLTimeout": "soon"}`:                                                  "invalid patchURLTimeout",
+		`{"slowThreshold": "soon"}`:                                                    "invalid slowThreshold",
+	}
+	for stdin, want := range invalid {
+		conf, jsonErr := decodeConf([]byte(stdin))
+		if jsonErr != nil {
+			t.Fatal(jsonErr)
+		}
+		err := conf.Validate()
+		if err == nil || err.Code != types.ErrInvalidNetworkConfig || !strings.Contains(err.Msg, want) {
+			t.Errorf("%s: expected an error about %s, got %v", stdin, want, err)
+		}
+	}
+
+	conf, jsonErr := decodeConf([]byte(`{"type": "gator", "plugin": "bridge", "patchFile": "patch.json", "configFile": "config.json", "skip": ["DEL"], "cleanOnSkip": true}`))
+	if jsonErr != nil {
+		t.Fatal(jsonErr)
+	}
+	if err := conf.Validate(); err != nil {
+		t.Errorf("expected a valid config, got %v", err)
+	}
+}
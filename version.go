@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/containernetworking/cni/pkg/types"
+)
+
+// queryVersions calls the downstream plugin with the VERSION command, and
+// returns the versions which it supports.
+func queryVersions(ctx context.Context, conf *PluginConfig) ([]string, *types.Error) {
+	pluginPath, err := getPluginPath(conf.Plugin)
+	if err != nil {
+		return nil, err
+	}
+	attr, attrErr := conf.sysProcAttr()
+	if attrErr != nil {
+		return nil, types.NewError(types.ErrInvalidNetworkConfig, "invalid runAsUser or runAsGroup", attrErr.Error())
+	}
+
+	stdin, _ := json.Marshal(map[string]interface{}{"cniVersion": conf.data["cniVersion"]})
+	env := setEnv(os.Environ(), "CNI_COMMAND", "VERSION")
+	stdout, stderr, exitcode := delegate(ctx, pluginPath, stdin, env, attr)
+	if exitcode != 0 {
+		return nil, types.NewError(
+			ErrVersionCheckFailed,
+			fmt.Sprintf("VERSION command failed with exit code %d", exitcode),
+			truncate(strings.TrimSpace(string(stderr)), maxLoggedStderr),
+		)
+	}
+
+	version := struct {
+		SupportedVersions []string `json:"supportedVersions"`
+	}{}
+	if err := json.Unmarshal(stdout, &version); err != nil {
+		return nil, types.NewError(
+			ErrVersionCheckFailed,
+			"failed to parse the output of the VERSION command",
+			err.Error(),
+		)
+	}
+	return version.SupportedVersions, nil
+}
+
+// checkVersion returns an error if the cniVersion of config is not one of the
+// supported versions. A config without a cniVersion is not checked.
+func checkVersion(supported []string, config []byte) *types.Error {
+	version := struct {
+		CNIVersion string `json:"cniVersion"`
+	}{}
+	if err := json.Unmarshal(config, &version); err != nil {
+		return types.NewError(
+			types.ErrDecodingFailure,
+			"failed to parse downstream config",
+			err.Error(),
+		)
+	}
+	if version.CNIVersion == "" || slices.Contains(supported, version.CNIVersion) {
+		return nil
+	}
+	return types.NewError(
+		types.ErrIncompatibleCNIVersion,
+		fmt.Sprintf("plugin does not support cniVersion %s", version.CNIVersion),
+		fmt.Sprintf("supported: %v", supported),
+	)
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/containernetworking/cni/pkg/types"
+)
+
+func TestParseConfDownstreamVersions(t *testing.T) {
+	dir := t.TempDir()
+	writeFakePlugin(t, dir, "versioned", `[ "$CNI_COMMAND" = VERSION ] && echo '{"cniVersion": "1.0.0", "supportedVersions": ["0.4.0", "1.0.0"]}'`)
+	t.Setenv("CNI_PATH", dir)
+	t.Setenv("CNI_COMMAND", "ADD")
+
+	stdin := []byte(`{
+		"cniVersion": "1.0.0",
+		"type": "gator",
+		"plugin": "versioned",
+		"checkVersion": true,
+		"patch": "{ {{ if has \"1.1.0\" .DownstreamVersions }}\"newField\"{{ else }}\"oldField\"{{ end }}: true }"
+	}`)
+	conf, err := parseConf(stdin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := map[string]interface{}{}
+	json.Unmarshal(conf.downstreamConfig, &out)
+	if out["oldField"] != true || out["newField"] != nil {
+		t.Fatalf("expected oldField for a plugin without 1.1.0, got %s", conf.downstreamConfig)
+	}
+
+	stdin = []byte(`{"cniVersion": "1.1.0", "type": "gator", "plugin": "versioned", "checkVersion": true}`)
+	if _, err := parseConf(stdin); err == nil || err.Code != types.ErrIncompatibleCNIVersion {
+		t.Fatalf("expected an incompatible version error, got %v", err)
+	}
+
+	writeFakePlugin(t, dir, "broken", `exit 1`)
+	stdin = []byte(`{"cniVersion": "1.0.0", "type": "gator", "plugin": "broken", "checkVersion": true}`)
+	if _, err := parseConf(stdin); err == nil || err.Code != ErrVersionCheckFailed {
+		t.Fatalf("expected a version check error, got %v", err)
+	}
+}
+
+func TestRunDryRunCheck(t *testing.T) {
+	dir := t.TempDir()
+	called := filepath.Join(dir, "called")
+	writeFakePlugin(t, dir, "versioned", `[ "$CNI_COMMAND" = VERSION ] || touch `+called+`; echo '{"cniVersion": "1.0.0", "supportedVersions": ["0.4.0", "1.0.0"]}'`)
+	t.Setenv("CNI_PATH", dir)
+	t.Setenv("CNI_COMMAND", "ADD")
+
+	tests := map[string]struct {
+		stdin string
+		code  int
+	}{
+		"supported": {
+			stdin: `{"cniVersion": "1.0.0", "type": "gator", "plugin": "versioned"}`,
+			code:  0,
+		},
+		"incompatible": {
+			stdin: `{"cniVersion": "0.4.0", "type": "gator", "plugin": "versioned", "patch": "{\"cniVersion\": \"1.1.0\"}", "protectedKeys": []}`,
+			code:  int(types.ErrIncompatibleCNIVersion),
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+			if code := run([]string{"--dry-run", "--check"}, bytes.NewBufferString(tt.stdin), stdout, stderr); code != tt.code {
+				t.Fatalf("expected exit code %d, got %d: %s", tt.code, code, stderr)
+			}
+			if tt.code != 0 && !strings.Contains(stdout.String(), "1.1.0") {
+				t.Fatalf("expected the unsupported version to be reported, got %s", stdout)
+			}
+		})
+	}
+	if _, err := os.Stat(called); err == nil {
+		t.Fatal("expected only the VERSION command to be called")
+	}
+}
+
+func TestCheckVersionDisallowedPlugin(t *testing.T) {
+	dir := t.TempDir()
+	called := filepath.Join(dir, "called")
+	writeFakePlugin(t, dir, "evil", `touch `+called+`; echo '{"cniVersion": "1.0.0", "supportedVersions": ["1.0.0"]}'`)
+	t.Setenv("CNI_PATH", dir)
+	t.Setenv("CNI_COMMAND", "ADD")
+
+	stdin := `{"cniVersion": "1.0.0", "type": "gator", "plugin": "evil", "allowedPlugins": ["debug"]}`
+	tests := map[string]struct {
+		stdin string
+		args  []string
+	}{
+		"checkVersion": {
+			stdin: strings.Replace(stdin, `"type"`, `"checkVersion": true, "type"`, 1),
+		},
+		"--check": {
+			stdin: stdin,
+			args:  []string{"--dry-run", "--check"},
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			stdout := &bytes.Buffer{}
+			if code := run(tt.args, bytes.NewBufferString(tt.stdin), stdout, &bytes.Buffer{}); code != ErrPluginNotAllowed {
+				t.Fatalf("expected exit code %d, got %d: %s", ErrPluginNotAllowed, code, stdout)
+			}
+			if _, err := os.Stat(called); err == nil {
+				t.Fatal("expected the disallowed plugin not to be executed")
+			}
+		})
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"github.com/containernetworking/cni/pkg/types"
+)
+
+// warn logs a non-fatal issue, and collects it so that it is added to the
+// warnings of the result if the downstream plugin succeeds.
+func (conf *PluginConfig) warn(msg string) {
+	logger.Warn(msg)
+	conf.warnings = append(conf.warnings, msg)
+}
+
+// addWarnings appends warnings to the warnings array of result, which CNI 1.1
+// allows in successful results. If there are no warnings, or the plugin did
+// not print a result, result is returned unchanged.
+func addWarnings(warnings []string, result []byte) ([]byte, *types.Error) {
+	if len(warnings) == 0 || len(bytes.TrimSpace(result)) == 0 {
+		return result, nil
+	}
+
+	parsed := map[string]json.RawMessage{}
+	if err := json.Unmarshal(result, &parsed); err != nil {
+		return nil, types.NewError(
+			types.ErrDecodingFailure,
+			"failed to parse the result of the downstream plugin",
+			err.Error(),
+		)
+	}
+	all := []interface{}{}
+	if existing, ok := parsed["warnings"]; ok {
+		if err := json.Unmarshal(existing, &all); err != nil {
+			return nil, types.NewError(
+				types.ErrDecodingFailure,
+				"failed to parse the warnings of the downstream plugin",
+				err.Error(),
+			)
+		}
+	}
+	for _, w := range warnings {
+		all = append(all, w)
+	}
+	parsed["warnings"], _ = json.Marshal(all)
+
+	out, err := json.Marshal(parsed)
+	if err != nil {
+		return nil, types.NewError(ErrMergeJSONFailed, "failed to add warnings to the result", err.Error())
+	}
+	return out, nil
+}
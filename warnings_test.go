@@ -0,0 +1,39 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestRunWarnings(t *testing.T) {
+	dir := t.TempDir()
+	writeFakePlugin(t, dir, "warned", `echo '{"cniVersion": "1.1.0", "warnings": ["from the plugin"]}'`)
+	t.Setenv("CNI_PATH", dir)
+	t.Setenv("CNI_COMMAND", "ADD")
+
+	stdin := `{"cniVersion": "1.1.0", "type": "gator", "plugin": "warned", "envMap": {"/cluster": "GATOR_TEST_UNSET"}}`
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	if code := run(nil, bytes.NewBufferString(stdin), stdout, stderr); code != 0 {
+		t.Fatalf("expected exit code 0, got %d: %s", code, stderr)
+	}
+
+	result := struct {
+		Warnings []string `json:"warnings"`
+	}{}
+	if err := json.Unmarshal(stdout.Bytes(), &result); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"from the plugin", "envMap: GATOR_TEST_UNSET is not set, so /cluster was not changed"}
+	if !reflect.DeepEqual(result.Warnings, want) {
+		t.Fatalf("expected warnings %q, got %q", want, result.Warnings)
+	}
+}
+
+func TestAddWarningsNoResult(t *testing.T) {
+	out, err := addWarnings([]string{"ignored"}, nil)
+	if err != nil || len(out) != 0 {
+		t.Fatalf("expected an empty result to be unchanged, got %q, %v", out, err)
+	}
+}